@@ -0,0 +1,29 @@
+package chat
+
+import (
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+)
+
+// screen runs a message sent by a client through the room's automod. It
+// reports whether the message may go on to the room, answering the client
+// itself when it may not.
+func (r *room) screen(client *Client, nonce string, message *data.Message) bool {
+	if r.filter == nil {
+		return true
+	}
+
+	result := r.filter.Check(string(message.Message))
+	switch result.Action {
+	case "":
+		return true
+	case data.AutomodReplace:
+		message.Message = []byte(result.Text)
+		return true
+	case data.AutomodHold:
+		r.hold(client, nonce, message, result.Reason)
+		return false
+	default:
+		r.send(client, errorFrame(nonce, CodeAutomodBlocked, "your message was blocked: "+result.Reason))
+		return false
+	}
+}
@@ -2,73 +2,351 @@ package chat
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/JunJie-Lai/Chat-App/internal/data"
 	"github.com/coder/websocket"
 	"github.com/coder/websocket/wsjson"
 	"log/slog"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
+// resumeGrace is how long a session is kept after its connection drops, so the
+// client can reconnect with its resume token without joining the room again.
+const resumeGrace = 30 * time.Second
+
+// typingInterval is the least time between two typing indicators of a client.
+const typingInterval = 3 * time.Second
+
 type Client struct {
-	Conn      *websocket.Conn
-	Logger    *slog.Logger
-	User      *data.User
-	Message   chan *data.Message
-	Server    *Server
-	RoomID    int64
-	CloseSlow func()
+	Conn          *websocket.Conn
+	Logger        *slog.Logger
+	User          *data.User
+	Send          chan *Frame
+	Server        *Server
+	RoomID        int64
+	LastMessageID int64
+
+	// SessionID is the login session the client connected with, empty for
+	// anonymous clients
+	SessionID string
+
+	mu          sync.Mutex
+	resumeToken string
+	closed      bool
+	sendClosed  bool
+	unsent      *Frame
+	grace       *time.Timer
+	written     chan struct{}
 }
 
-func (client *Client) ReadMessage() {
-	defer func(Conn *websocket.Conn) {
-		client.Server.Unregister <- client
-		if err := Conn.Close(websocket.StatusNormalClosure, "Disconnected."); err != nil {
-			client.Logger.Error(err.Error())
-			return
+func newResumeToken() (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// Attach makes conn the connection of the client, sends the client its resume
+// token and starts reading and writing on conn.
+func (client *Client) Attach(conn *websocket.Conn) error {
+	client.mu.Lock()
+	if client.resumeToken == "" {
+		token, err := newResumeToken()
+		if err != nil {
+			client.mu.Unlock()
+			return err
 		}
-	}(client.Conn)
+		client.resumeToken = token
+	}
+	token := client.resumeToken
+	client.Conn = conn
+	written := client.written
+	client.mu.Unlock()
 
+	client.Server.track(token, client)
+
+	// Wait for the writer of the previous connection to give up the message channel
+	if written != nil {
+		<-written
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := newFrame(FrameSession, "", SessionPayload{ResumeToken: token})
+	if err != nil {
+		return err
+	}
+	if err := wsjson.Write(ctx, conn, session); err != nil {
+		client.Server.detach(client, conn)
+		return err
+	}
+
+	client.mu.Lock()
+	client.written = make(chan struct{})
+	written = client.written
+	client.mu.Unlock()
+
+	var connCtx context.Context
+	if client.User.IsAnonymous() {
+		connCtx = conn.CloseRead(context.Background())
+		go func() {
+			<-connCtx.Done()
+			client.Server.detach(client, conn)
+		}()
+	} else {
+		var cancel context.CancelFunc
+		connCtx, cancel = context.WithCancel(context.Background())
+		go client.ReadMessage(conn, cancel)
+	}
+	go client.WriteMessage(connCtx, conn, written)
+	return nil
+}
+
+func (client *Client) ReadMessage(conn *websocket.Conn, cancel context.CancelFunc) {
+	defer func() {
+		cancel()
+		client.Server.detach(client, conn)
+	}()
+
+	var (
+		lastTyping time.Time
+		limited    violations
+	)
 	for {
-		_, message, err := client.Conn.Read(context.Background())
+		_, msg, err := conn.Read(context.Background())
 		if err != nil {
-			if websocket.CloseStatus(err) != websocket.StatusGoingAway {
-				client.Logger.Error("Read error: ", err.Error())
+			if websocket.CloseStatus(err) != websocket.StatusGoingAway && websocket.CloseStatus(err) != websocket.StatusNormalClosure {
+				client.Logger.Error("Read error: " + err.Error())
 			}
 			break
 		}
 
-		client.Server.Broadcast <- &data.Message{
-			Username:  client.User.Name,
-			Message:   message,
-			Timestamp: time.Now(),
-			RoomID:    client.RoomID,
-			SuperChat: false,
+		var frame Frame
+		if err := json.Unmarshal(msg, &frame); err != nil {
+			client.push(errorFrame("", CodeBadFrame, "frame must be a JSON object with a type"))
+			continue
+		}
+		if frame.Version != ProtocolVersion {
+			client.push(errorFrame(frame.ID, CodeUnsupportedVersion, fmt.Sprintf("protocol version %d is not supported", frame.Version)))
+			continue
+		}
+
+		switch frame.Type {
+		case FrameMessage, FrameEdit, FrameDelete:
+			ok, disconnected := client.limit(frame.ID, &limited)
+			if disconnected {
+				return
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		switch frame.Type {
+		case FrameMessage:
+			var payload SendPayload
+			if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+				client.push(errorFrame(frame.ID, CodeBadFrame, "message payload must contain text"))
+				continue
+			}
+			if code, reason := validateText(payload.Text); code != "" {
+				client.push(errorFrame(frame.ID, code, reason))
+				continue
+			}
+			if !client.User.Activated {
+				client.push(errorFrame(frame.ID, CodeInactiveAccount, "your user account must be activated to chat"))
+				continue
+			}
+
+			client.Server.submit <- &submission{
+				client: client,
+				nonce:  frame.ID,
+				message: &data.Message{
+					UserID:    client.User.ID,
+					Username:  client.User.Name,
+					Message:   []byte(payload.Text),
+					Timestamp: time.Now(),
+					RoomID:    client.RoomID,
+					SuperChat: false,
+				},
+			}
+		case FrameEdit:
+			var payload EditPayload
+			if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+				client.push(errorFrame(frame.ID, CodeBadFrame, "edit payload must contain message_id and text"))
+				continue
+			}
+			if code, reason := validateText(payload.Text); code != "" {
+				client.push(errorFrame(frame.ID, code, reason))
+				continue
+			}
+
+			message, err := client.Server.EditMessage(client.RoomID, payload.MessageID, client.User, payload.Text)
+			client.reply(frame.ID, message, err)
+		case FrameDelete:
+			var payload DeletePayload
+			if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+				client.push(errorFrame(frame.ID, CodeBadFrame, "delete payload must contain message_id"))
+				continue
+			}
+
+			message, err := client.Server.DeleteMessage(client.RoomID, payload.MessageID, client.User)
+			client.reply(frame.ID, message, err)
+		case FrameApprove, FrameDeny:
+			client.review(&frame, frame.Type == FrameApprove)
+		case FrameTyping:
+			// Typing indicators are ephemeral and throttled, they never reach the history
+			if time.Since(lastTyping) < typingInterval {
+				continue
+			}
+			lastTyping = time.Now()
+
+			// The room decides whether the user may be seen typing
+			client.Server.submit <- &submission{client: client, typing: true}
+		case FramePing:
+			client.push(&Frame{Version: ProtocolVersion, Type: FramePong, ID: frame.ID})
+		default:
+			client.push(errorFrame(frame.ID, CodeUnknownType, fmt.Sprintf("frame type %q is not supported", frame.Type)))
 		}
 	}
 }
 
-func (client *Client) WriteMessage() {
-	defer func(Conn *websocket.Conn) {
-		if err := Conn.Close(websocket.StatusNormalClosure, "Disconnected."); err != nil {
+func validateText(text string) (string, string) {
+	switch {
+	case strings.TrimSpace(text) == "":
+		return CodeEmptyMessage, "message must not be empty"
+	case utf8.RuneCountInString(text) > data.MaxMessageLength:
+		return CodeTooLong, fmt.Sprintf("message must not be more than %d characters", data.MaxMessageLength)
+	default:
+		return "", ""
+	}
+}
+
+// reply acknowledges the request frame with the given ID, or tells the client
+// why it failed.
+func (client *Client) reply(id string, message *data.Message, err error) {
+	var frame *Frame
+	switch {
+	case err == nil:
+		frame, err = newFrame(FrameAck, id, AckPayload{MessageID: message.ID})
+		if err != nil {
+			client.Logger.Error(err.Error(), "room_id", client.RoomID)
 			return
 		}
-	}(client.Conn)
+	case errors.Is(err, data.ErrRecordNotFound):
+		frame = errorFrame(id, CodeNotFound, "the message could not be found")
+	case errors.Is(err, ErrNotPermitted):
+		frame = errorFrame(id, CodeNotPermitted, "you are not allowed to change this message")
+	case errors.Is(err, ErrEditWindowPassed):
+		frame = errorFrame(id, CodeEditWindowPassed, "the message is too old to be changed")
+	case errors.Is(err, data.ErrEditConflict):
+		frame = errorFrame(id, CodeEditConflict, "the message was changed at the same time, please try again")
+	case errors.Is(err, ErrBanned):
+		frame = errorFrame(id, CodeBanned, "you are not allowed to chat in this channel")
+	default:
+		client.Logger.Error(err.Error(), "room_id", client.RoomID)
+		frame = errorFrame(id, CodeServerError, "the server encountered a problem and could not process your request")
+	}
+	client.push(frame)
+}
+
+// push queues the frame for the client without blocking, it reports whether
+// the frame could be queued.
+func (client *Client) push(frame *Frame) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.sendClosed {
+		return false
+	}
+
+	select {
+	case client.Send <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
+func (client *Client) closeSend() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.sendClosed = true
+	close(client.Send)
+}
+
+func (client *Client) WriteMessage(ctx context.Context, conn *websocket.Conn, written chan struct{}) {
+	defer close(written)
 
 	for {
-		if err := wsjson.Write(context.Background(), client.Conn, <-client.Message); err != nil {
-			if websocket.CloseStatus(err) != -1 {
-				client.Logger.Error("Write error: ", err.Error())
+		client.mu.Lock()
+		msg := client.unsent
+		client.unsent = nil
+		client.mu.Unlock()
+
+		if msg == nil {
+			var ok bool
+			select {
+			case msg, ok = <-client.Send:
+				if !ok {
+					if err := conn.Close(websocket.StatusNormalClosure, "Disconnected."); err != nil {
+						return
+					}
+					return
+				}
+			case <-ctx.Done():
+				return
 			}
-			break
 		}
 
-		for msg := range client.Message {
-			if err := wsjson.Write(context.Background(), client.Conn, msg); err != nil {
-				if websocket.CloseStatus(err) != -1 {
-					client.Logger.Error("Write message history error: ", err.Error())
-				}
-				break
+		if err := wsjson.Write(ctx, conn, msg); err != nil {
+			// Keep the message for the connection the session is resumed on
+			client.mu.Lock()
+			client.unsent = msg
+			client.mu.Unlock()
+
+			if websocket.CloseStatus(err) == -1 && ctx.Err() == nil {
+				client.Logger.Error("Write error: " + err.Error())
 			}
+			return
 		}
 	}
 }
+
+func (client *Client) CloseSlow() {
+	client.close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages")
+}
+
+func (client *Client) close(code websocket.StatusCode, reason string) {
+	client.mu.Lock()
+	conn := client.Conn
+	client.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+	if err := conn.Close(code, reason); err != nil {
+		return
+	}
+}
+
+// end marks the session as over, it can no longer be resumed.
+func (client *Client) end() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	client.closed = true
+	if client.grace != nil {
+		client.grace.Stop()
+	}
+}
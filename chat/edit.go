@@ -0,0 +1,86 @@
+package chat
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"time"
+)
+
+// editWindow is how long authors can edit or delete their own messages.
+const editWindow = 15 * time.Minute
+
+var (
+	ErrNotPermitted     = errors.New("not permitted")
+	ErrEditWindowPassed = errors.New("edit window passed")
+)
+
+// EditMessage changes the text of a message the user wrote within the edit
+// window and sends the new version to everyone in the room.
+func (server *Server) EditMessage(roomID, messageID int64, user *data.User, text string) (*data.Message, error) {
+	message, err := server.models.Message.GetByID(roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.Deleted {
+		return nil, data.ErrRecordNotFound
+	}
+	if message.UserID != user.ID || message.SuperChat {
+		return nil, ErrNotPermitted
+	}
+	if time.Since(message.Timestamp) > editWindow {
+		return nil, ErrEditWindowPassed
+	}
+	if err := server.checkBan(roomID, user); err != nil {
+		return nil, err
+	}
+
+	if err := server.models.Message.Edit(message, user.ID, []byte(text)); err != nil {
+		return nil, err
+	}
+
+	if err := server.publishFrame(roomID, FrameEdit, message); err != nil {
+		server.logger.Error(err.Error(), "room_id", roomID)
+	}
+	return message, nil
+}
+
+// DeleteMessage turns a message into a tombstone and sends it to everyone in
+// the room. Authors can delete their own messages within the edit window, the
+// channel owner and moderators can delete any message.
+func (server *Server) DeleteMessage(roomID, messageID int64, user *data.User) (*data.Message, error) {
+	message, err := server.models.Message.GetByID(roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message.Deleted {
+		return nil, data.ErrRecordNotFound
+	}
+
+	moderator, err := server.IsModerator(roomID, user)
+	if err != nil {
+		return nil, err
+	}
+	if !moderator {
+		if message.UserID != user.ID {
+			return nil, ErrNotPermitted
+		}
+		if time.Since(message.Timestamp) > editWindow {
+			return nil, ErrEditWindowPassed
+		}
+	}
+
+	before := *message
+	if err := server.models.Message.Delete(message, user.ID); err != nil {
+		return nil, err
+	}
+
+	// Moderators removing other people's messages is recorded in the audit log
+	if message.UserID != user.ID {
+		server.audit(roomID, user.ID, data.AuditMessageDelete, data.TargetMessage, message.ID, &before, nil)
+	}
+
+	if err := server.publishFrame(roomID, FrameDelete, message); err != nil {
+		server.logger.Error(err.Error(), "room_id", roomID)
+	}
+	return message, nil
+}
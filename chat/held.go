@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"encoding/json"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"time"
+)
+
+const (
+	HeldApproved = "approved"
+	HeldDenied   = "denied"
+)
+
+// Hold keeps the message from the room until a moderator approves or denies it,
+// and shows it to the moderators in the room.
+func (server *Server) Hold(message *data.Message, reason string) error {
+	if message.ID == 0 {
+		message.ID = server.nextID(message.RoomID)
+	}
+
+	held := &data.HeldMessage{Message: message, Reason: reason, HeldAt: time.Now()}
+	if err := server.models.HeldMessage.Hold(held); err != nil {
+		return err
+	}
+
+	frame, err := newFrame(FrameHeld, "", held)
+	if err != nil {
+		return err
+	}
+	if err := server.publish(message.RoomID, &event{Kind: eventFrame, Frame: frame, Moderators: true}); err != nil {
+		server.logger.Error(err.Error(), "room_id", message.RoomID)
+	}
+	return nil
+}
+
+// Approve sends a held message to the room. It keeps the time it was sent at
+// but gets a new ID, as the room may have moved on in the meantime.
+func (server *Server) Approve(roomID, messageID int64, moderator *data.User) (*data.Message, error) {
+	held, err := server.models.HeldMessage.Release(roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	server.audit(roomID, moderator.ID, data.AuditHeldApprove, data.TargetMessage, messageID, nil, held.Message)
+	server.released(roomID, messageID, HeldApproved, moderator)
+
+	message := held.Message
+	message.ID = 0
+	server.Broadcast <- message
+	return message, nil
+}
+
+// Deny drops a held message for good.
+func (server *Server) Deny(roomID, messageID int64, moderator *data.User) (*data.HeldMessage, error) {
+	held, err := server.models.HeldMessage.Release(roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	server.audit(roomID, moderator.ID, data.AuditHeldDeny, data.TargetMessage, messageID, held, nil)
+	server.released(roomID, messageID, HeldDenied, moderator)
+	return held, nil
+}
+
+// released tells the moderators in the room that a held message has been dealt
+// with, so it drops out of everyone's queue.
+func (server *Server) released(roomID, messageID int64, action string, moderator *data.User) {
+	payload := ReleasedPayload{MessageID: messageID, Action: action, ModeratorID: moderator.ID, Moderator: moderator.Name}
+	frame, err := newFrame(FrameReleased, "", payload)
+	if err == nil {
+		err = server.publish(roomID, &event{Kind: eventFrame, Frame: frame, Moderators: true})
+	}
+	if err != nil {
+		server.logger.Error(err.Error(), "room_id", roomID)
+	}
+}
+
+// Trusted reports whether messages of the user go straight to the room under
+// the room's trust threshold, or have to be held for review first.
+func Trusted(user *data.User, modes data.ChatModes) bool {
+	return modes.TrustThreshold == 0 || time.Since(user.CreatedAt) >= time.Duration(modes.TrustThreshold)*time.Second
+}
+
+// vet holds messages from users the room doesn't trust yet. It reports whether
+// the message may go on to the room. The owner and moderators are exempt.
+func (r *room) vet(client *Client, nonce string, message *data.Message) bool {
+	if r.isModerator(client.User.ID) || Trusted(client.User, r.modes) {
+		return true
+	}
+	r.hold(client, nonce, message, "account is newer than the channel's trust threshold")
+	return false
+}
+
+// hold puts a message sent by a client in the room's queue and tells the client
+// it is waiting for review.
+func (r *room) hold(client *Client, nonce string, message *data.Message, reason string) {
+	if err := r.server.Hold(message, reason); err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		r.send(client, errorFrame(nonce, CodePersistenceFailed, "the message could not be saved, please try again"))
+		return
+	}
+
+	frame, err := newFrame(FrameAck, nonce, AckPayload{MessageID: message.ID, Held: true})
+	if err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		return
+	}
+	r.send(client, frame)
+}
+
+// review approves or denies a held message for a moderator over the websocket.
+func (client *Client) review(frame *Frame, approve bool) {
+	var payload ReviewPayload
+	if err := json.Unmarshal(frame.Payload, &payload); err != nil {
+		client.push(errorFrame(frame.ID, CodeBadFrame, frame.Type+" payload must contain message_id"))
+		return
+	}
+
+	moderator, err := client.Server.IsModerator(client.RoomID, client.User)
+	if err != nil {
+		client.reply(frame.ID, nil, err)
+		return
+	}
+	if !moderator {
+		client.push(errorFrame(frame.ID, CodeNotPermitted, "only moderators can review held messages"))
+		return
+	}
+
+	switch {
+	case approve:
+		_, err = client.Server.Approve(client.RoomID, payload.MessageID, client.User)
+	default:
+		_, err = client.Server.Deny(client.RoomID, payload.MessageID, client.User)
+	}
+	client.reply(frame.ID, &data.Message{ID: payload.MessageID}, err)
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits     = 10
+	sequenceBits = 12
+	maxNode      = 1<<nodeBits - 1
+	maxSequence  = 1<<sequenceBits - 1
+	timeShift    = nodeBits + sequenceBits
+)
+
+// epoch is the start of the timestamp part of message IDs.
+var epoch = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// idGenerator hands out snowflake style message IDs: 41 bits of milliseconds
+// since epoch, 10 bits of node and 12 bits of sequence. IDs from one node are
+// strictly increasing and sort by time across nodes.
+type idGenerator struct {
+	mu       sync.Mutex
+	node     int64
+	last     int64
+	sequence int64
+}
+
+func newIDGenerator(node int64) *idGenerator {
+	return &idGenerator{node: node & maxNode}
+}
+
+// Next returns a new ID that is greater than floor, the newest ID already seen
+// in the room, even if the node that produced floor has a clock running ahead.
+func (g *idGenerator) Next(floor int64) int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Since(epoch).Milliseconds()
+	if floorTime := floor >> timeShift; floorTime > now {
+		now = floorTime
+	}
+
+	switch {
+	case now > g.last:
+		g.last = now
+		g.sequence = 0
+	default:
+		// Same millisecond, or the clock went backwards
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			g.last++
+		}
+	}
+
+	id := g.last<<timeShift | g.node<<sequenceBits | g.sequence
+	if id <= floor {
+		g.last++
+		g.sequence = 0
+		id = g.last<<timeShift | g.node<<sequenceBits
+	}
+	return id
+}
@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextIsMonotonic(t *testing.T) {
+	g := newIDGenerator(7)
+
+	var last int64
+	for i := 0; i < 100_000; i++ {
+		id := g.Next(0)
+		if id <= last {
+			t.Fatalf("ID %d after %d is not increasing", id, last)
+		}
+		if node := id >> sequenceBits & maxNode; node != 7 {
+			t.Fatalf("ID %d has node %d, want 7", id, node)
+		}
+		last = id
+	}
+}
+
+func TestNextStaysAboveFloorAheadOfClock(t *testing.T) {
+	g := newIDGenerator(1)
+
+	// Another node with its clock an hour ahead produced the newest ID
+	ahead := time.Since(epoch).Milliseconds() + time.Hour.Milliseconds()
+	tests := []struct {
+		name  string
+		floor int64
+	}{
+		{"lower node", ahead<<timeShift | 0<<sequenceBits | 5},
+		{"higher node", ahead<<timeShift | maxNode<<sequenceBits | maxSequence},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := g.Next(tt.floor)
+			if id <= tt.floor {
+				t.Fatalf("ID %d is not above floor %d", id, tt.floor)
+			}
+			if next := g.Next(0); next <= id {
+				t.Fatalf("ID %d after %d went back to the local clock", next, id)
+			}
+		})
+	}
+}
+
+func TestNextRollsOverSequence(t *testing.T) {
+	g := newIDGenerator(3)
+
+	// Pinning every ID to a millisecond ahead of the clock keeps them all in it
+	// until the sequence runs out
+	ms := time.Since(epoch).Milliseconds() + time.Hour.Milliseconds()
+	floor := ms << timeShift
+
+	var last int64
+	for i := 0; i <= maxSequence+1; i++ {
+		id := g.Next(floor)
+		if id <= last {
+			t.Fatalf("ID %d after %d is not increasing", id, last)
+		}
+		last = id
+
+		want := ms
+		if i == maxSequence+1 {
+			want = ms + 1
+		}
+		if got := id >> timeShift; got != want {
+			t.Fatalf("ID %d is in millisecond %d, want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package chat
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"slices"
+	"time"
+)
+
+var ErrBanned = errors.New("banned from the channel")
+
+// Banned applies a new ban or timeout to the room on every node. Banned users
+// are kicked out of the room, timed out users can stay and watch until their
+// timeout runs out. Shadow banned users aren't told anything.
+func (server *Server) Banned(ban *data.Ban) error {
+	if err := server.Reload(ban.ChannelID); err != nil {
+		return err
+	}
+	if ban.Shadow {
+		return nil
+	}
+	if ban.IsTimeout() {
+		frame, err := newFrame(FrameNotice, "", NoticePayload{Text: "you have been timed out until " + ban.ExpiresAt.Format(time.RFC3339)})
+		if err != nil {
+			return err
+		}
+		return server.publish(ban.ChannelID, &event{Kind: eventFrame, UserID: ban.UserID, Frame: frame})
+	}
+	return server.publish(ban.ChannelID, &event{Kind: eventKick, UserID: ban.UserID})
+}
+
+// Unbanned lifts the ban or timeout of a user in the room on every node.
+func (server *Server) Unbanned(roomID int64) error {
+	return server.Reload(roomID)
+}
+
+// IsModerator reports whether the user owns the channel of the room or has been
+// made one of its moderators.
+func (server *Server) IsModerator(roomID int64, user *data.User) (bool, error) {
+	if user.IsAnonymous() {
+		return false, nil
+	}
+
+	channel, err := server.models.Channel.GetExistingChannel(roomID)
+	if err != nil {
+		return false, err
+	}
+	if channel.UserID == user.ID {
+		return true, nil
+	}
+	return server.models.Moderation.IsModerator(roomID, user.ID)
+}
+
+// audit records a moderator's action in the channel's audit log. Failing to do
+// so is logged rather than undoing the action.
+func (server *Server) audit(roomID, actorID int64, action, targetType string, targetID int64, before, after any) {
+	entry, err := data.NewAuditEntry(roomID, actorID, action, targetType, targetID, before, after)
+	if err == nil {
+		err = server.models.Audit.Insert(entry)
+	}
+	if err != nil {
+		server.logger.Error(err.Error(), "room_id", roomID)
+	}
+}
+
+// checkBan returns ErrBanned if the user is banned or timed out in the room.
+// Shadow banned users get ErrRecordNotFound instead, as if their message was
+// already gone.
+func (server *Server) checkBan(roomID int64, user *data.User) error {
+	ban, err := server.models.Moderation.GetBan(roomID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return nil
+		default:
+			return err
+		}
+	}
+	if ban.Shadow {
+		return data.ErrRecordNotFound
+	}
+	return ErrBanned
+}
+
+// Shadow echoes a message of a shadow banned user back to that user only, for
+// messages that don't come through the user's websocket.
+func (server *Server) Shadow(message *data.Message) error {
+	message.ID = server.nextID(message.RoomID)
+	return server.shadow(message, server.settings(message.RoomID))
+}
+
+// shadow keeps the message out of the room's history and sends it to the
+// connections of its sender on every node, so to them it looks like it went
+// through.
+func (server *Server) shadow(message *data.Message, history data.HistorySettings) error {
+	if err := server.models.ShadowMessage.Add(message, history); err != nil {
+		return fmt.Errorf("%w: %w", errNotPersisted, err)
+	}
+
+	frame, err := messageFrame(message)
+	if err != nil {
+		return err
+	}
+	if err := server.publish(message.RoomID, &event{Kind: eventFrame, UserID: message.UserID, Frame: frame}); err != nil {
+		return fmt.Errorf("%w: %w", errNotPublished, err)
+	}
+	return nil
+}
+
+// withShadowed adds the shadowed messages of the client's user to the history
+// replayed to it, so a shadow banned user keeps seeing their own messages.
+func (server *Server) withShadowed(client *Client, messages []*data.Message) ([]*data.Message, error) {
+	after := client.LastMessageID
+	if after == 0 && len(messages) > 0 {
+		after = messages[0].ID
+	}
+
+	shadowed, err := server.models.ShadowMessage.GetAfter(client.RoomID, client.User.ID, after)
+	if err != nil {
+		return nil, err
+	}
+
+	messages = append(messages, shadowed...)
+	slices.SortFunc(messages, func(a, b *data.Message) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	if len(messages) > maxReplay {
+		messages = messages[len(messages)-maxReplay:]
+	}
+	return messages, nil
+}
+
+// shadowed reports whether the user is shadow banned in the room.
+func (r *room) shadowed(userID int64) bool {
+	ban, ok := r.bans[userID]
+	return ok && ban.Shadow && ban.Active()
+}
+
+func banFrame(id string, ban *data.Ban) *Frame {
+	if ban.IsTimeout() {
+		return errorFrame(id, CodeTimedOut, "you are timed out until "+ban.ExpiresAt.Format(time.RFC3339))
+	}
+	return errorFrame(id, CodeBanned, "you are banned from this channel")
+}
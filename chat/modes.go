@@ -0,0 +1,206 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// emoteRX matches emote codes like :wave:, the only text allowed in emote-only mode.
+var emoteRX = regexp.MustCompile(`:[A-Za-z0-9_]+:`)
+
+type followState struct {
+	userID     int64
+	followedAt *time.Time
+}
+
+// ModesChanged makes every node pick up the new chat modes of the room and
+// tells the room what changed.
+func (server *Server) ModesChanged(roomID int64, before, after data.ChatModes) error {
+	if err := server.Reload(roomID); err != nil {
+		return err
+	}
+
+	var notices []string
+	if before.SlowMode != after.SlowMode {
+		if after.SlowMode > 0 {
+			notices = append(notices, fmt.Sprintf("slow mode is on, you can send a message every %d seconds", after.SlowMode))
+		} else {
+			notices = append(notices, "slow mode is off")
+		}
+	}
+	if before.FollowersOnly != after.FollowersOnly {
+		if after.FollowersOnly {
+			notices = append(notices, "followers-only mode is on, only followers of the channel can chat")
+		} else {
+			notices = append(notices, "followers-only mode is off")
+		}
+	}
+	if before.MinAccountAge != after.MinAccountAge {
+		if after.MinAccountAge > 0 {
+			notices = append(notices, "accounts must be at least "+(time.Duration(after.MinAccountAge)*time.Second).String()+" old to chat")
+		} else {
+			notices = append(notices, "there is no minimum account age to chat")
+		}
+	}
+	if before.TrustThreshold != after.TrustThreshold {
+		if after.TrustThreshold > 0 {
+			notices = append(notices, "messages from accounts newer than "+(time.Duration(after.TrustThreshold)*time.Second).String()+" are held for review")
+		} else {
+			notices = append(notices, "messages are no longer held for review because of account age")
+		}
+	}
+	if before.EmoteOnly != after.EmoteOnly {
+		if after.EmoteOnly {
+			notices = append(notices, "emote-only mode is on")
+		} else {
+			notices = append(notices, "emote-only mode is off")
+		}
+	}
+
+	for _, notice := range notices {
+		if err := server.Notice(roomID, notice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Followed makes every node look up again whether the user follows the room,
+// for when the user followed or unfollowed it.
+func (server *Server) Followed(roomID, userID int64) error {
+	return server.publish(roomID, &event{Kind: eventFollow, UserID: userID})
+}
+
+// enforceModes checks a message sent by a client against the chat modes of the
+// room, all but slow mode, which is left to cooldown. It reports whether the message may go on to the room, answering the
+// client itself when it may not. The owner and moderators are exempt.
+func (r *room) enforceModes(client *Client, nonce string, message *data.Message) bool {
+	user := client.User
+	if r.isModerator(user.ID) {
+		return true
+	}
+
+	if code, reason := r.restriction(user); code != "" {
+		r.send(client, errorFrame(nonce, code, reason))
+		return false
+	}
+	if r.modes.EmoteOnly && !isEmoteOnly(string(message.Message)) {
+		r.send(client, errorFrame(nonce, CodeEmoteOnly, "only emotes are allowed in this channel right now"))
+		return false
+	}
+	return true
+}
+
+// cooldown takes the user's slow mode cooldown in the room, reporting whether
+// the message may go out now and answering the client itself when it may not.
+// It is checked after every other check, automod and the trust hold included,
+// so rejected and held messages don't start the cooldown. The owner and
+// moderators are exempt.
+func (r *room) cooldown(client *Client, nonce string) bool {
+	user := client.User
+	if r.modes.SlowMode == 0 || r.isModerator(user.ID) {
+		return true
+	}
+
+	left, err := r.server.models.Cooldown.Take(r.id, user.ID, time.Duration(r.modes.SlowMode)*time.Second)
+	if err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		return true
+	}
+	if left > 0 {
+		r.send(client, errorFrame(nonce, CodeSlowMode, fmt.Sprintf("slow mode is on, wait %d more seconds", int(left.Round(time.Second).Seconds()))))
+		return false
+	}
+	return true
+}
+
+// restriction returns the error code and reason of the chat mode that keeps the
+// user from chatting in the room whatever they send, if any. Moderators are not
+// exempt here.
+func (r *room) restriction(user *data.User) (string, string) {
+	switch {
+	case r.modes.MinAccountAge > 0 && time.Since(user.CreatedAt) < time.Duration(r.modes.MinAccountAge)*time.Second:
+		return CodeAccountTooNew, "your account is too new to chat in this channel"
+	case r.modes.FollowersOnly && r.follows[user.ID] == nil:
+		return CodeFollowersOnly, "only followers of the channel can chat"
+	default:
+		return "", ""
+	}
+}
+
+// typing tells the room the client's user is typing, as long as the user may
+// chat in it. A shadow banned user is only shown typing on their own
+// connections, the same as their messages.
+func (r *room) typing(client *Client) {
+	user := client.User
+	if !user.Activated {
+		return
+	}
+	if ban, ok := r.bans[user.ID]; ok && !ban.Shadow && ban.Active() {
+		return
+	}
+	if !r.isModerator(user.ID) {
+		if code, _ := r.restriction(user); code != "" {
+			return
+		}
+	}
+
+	frame, err := newFrame(FrameTyping, "", TypingPayload{UserID: user.ID, Username: user.Name})
+	if err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		return
+	}
+	evt := &event{Kind: eventFrame, Frame: frame}
+	if r.shadowed(user.ID) {
+		evt.UserID = user.ID
+	}
+
+	go func() {
+		if err := r.server.publish(r.id, evt); err != nil {
+			r.server.logger.Error(err.Error(), "room_id", r.id)
+		}
+	}()
+}
+
+func (r *room) lookupFollow(userID int64) {
+	state := followState{userID: userID}
+
+	follow, err := r.server.models.Follow.GetFollow(r.id, userID)
+	switch {
+	case err == nil:
+		state.followedAt = &follow.CreatedAt
+	case !errors.Is(err, data.ErrRecordNotFound):
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		return
+	}
+
+	select {
+	case r.followed <- state:
+	case <-r.done:
+	}
+}
+
+// isEmoteOnly reports whether the text is made up of nothing but emoji and
+// emote codes.
+func isEmoteOnly(text string) bool {
+	text = emoteRX.ReplaceAllString(text, " ")
+	if strings.TrimSpace(text) == "" {
+		return true
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r), unicode.Is(unicode.So, r), unicode.Is(unicode.Sk, r), unicode.Is(unicode.Mn, r), unicode.Is(unicode.Me, r):
+		case r == '\u200d', r == '\ufe0f':
+			// Zero width joiners and variation selectors glue emoji together
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package chat
+
+import (
+	"encoding/json"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+)
+
+// ProtocolVersion is the version of the websocket frames, clients sending any
+// other version get an error frame back.
+const ProtocolVersion = 1
+
+const (
+	FrameMessage  = "message"
+	FrameNotice   = "notice"
+	FrameError    = "error"
+	FrameAck      = "ack"
+	FramePing     = "ping"
+	FramePong     = "pong"
+	FrameSession  = "session"
+	FramePresence = "presence"
+	FrameTyping   = "typing"
+	FrameEdit     = "edit"
+	FrameDelete   = "delete"
+	FrameHeld     = "held"
+	FrameReleased = "released"
+	FrameApprove  = "approve"
+	FrameDeny     = "deny"
+)
+
+const (
+	PresenceJoin    = "join"
+	PresenceLeave   = "leave"
+	PresenceViewers = "viewers"
+)
+
+const (
+	CodeBadFrame           = "bad_frame"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownType        = "unknown_type"
+	CodeEmptyMessage       = "empty_message"
+	CodeTooLong            = "too_long"
+	CodePersistenceFailed  = "persistence_failed"
+	CodeDeliveryFailed     = "delivery_failed"
+	CodeNotFound           = "not_found"
+	CodeNotPermitted       = "not_permitted"
+	CodeEditWindowPassed   = "edit_window_passed"
+	CodeEditConflict       = "edit_conflict"
+	CodeBanned             = "banned"
+	CodeTimedOut           = "timed_out"
+	CodeAutomodBlocked     = "automod_blocked"
+	CodeSlowMode           = "slow_mode"
+	CodeFollowersOnly      = "followers_only"
+	CodeAccountTooNew      = "account_too_new"
+	CodeEmoteOnly          = "emote_only"
+	CodeRateLimited        = "rate_limited"
+	CodeRoomBusy           = "room_busy"
+	CodeInactiveAccount    = "inactive_account"
+	CodeServerError        = "server_error"
+)
+
+// Frame is the envelope of everything sent over the websocket in both directions.
+// ID is set by the client on frames it sends and echoed on the frames that answer them.
+type Frame struct {
+	Version int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type SendPayload struct {
+	Text string `json:"text"`
+}
+
+type EditPayload struct {
+	MessageID int64  `json:"message_id,string"`
+	Text      string `json:"text"`
+}
+
+type DeletePayload struct {
+	MessageID int64 `json:"message_id,string"`
+}
+
+// AckPayload tells the client the ID of its message. Held messages are waiting
+// for a moderator and only reach the room once approved.
+type AckPayload struct {
+	MessageID int64 `json:"message_id,string"`
+	Held      bool  `json:"held,omitempty"`
+}
+
+// ReviewPayload is sent by moderators to approve or deny a held message.
+type ReviewPayload struct {
+	MessageID int64 `json:"message_id,string"`
+}
+
+// ReleasedPayload tells moderators that a held message was approved or denied.
+type ReleasedPayload struct {
+	MessageID   int64  `json:"message_id,string"`
+	Action      string `json:"action"`
+	ModeratorID int64  `json:"moderator_id"`
+	Moderator   string `json:"moderator_name"`
+}
+
+type NoticePayload struct {
+	Text string `json:"text"`
+}
+
+type PresencePayload struct {
+	Action   string `json:"action"`
+	UserID   int64  `json:"user_id,omitempty"`
+	Username string `json:"user_name,omitempty"`
+	Viewers  int    `json:"viewers"`
+}
+
+type TypingPayload struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"user_name"`
+}
+
+// ErrorPayload tells the client why its frame failed. RetryAfter is set in
+// milliseconds when the client is rate limited.
+type ErrorPayload struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int64  `json:"retry_after,omitempty"`
+}
+
+type SessionPayload struct {
+	ResumeToken string `json:"resume_token"`
+}
+
+func newFrame(frameType, id string, payload any) (*Frame, error) {
+	js, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Version: ProtocolVersion, Type: frameType, ID: id, Payload: js}, nil
+}
+
+func messageFrame(message *data.Message) (*Frame, error) {
+	return newFrame(FrameMessage, "", message)
+}
+
+func errorFrame(id, code, message string) *Frame {
+	frame, _ := newFrame(FrameError, id, ErrorPayload{Code: code, Message: message})
+	return frame
+}
@@ -0,0 +1,69 @@
+package chat
+
+import (
+	"fmt"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/coder/websocket"
+	"time"
+)
+
+var (
+	// roomBucket limits how fast a user can chat in a single room
+	roomBucket = data.Bucket{Rate: 1, Burst: 5}
+	// globalBucket limits how fast a user can chat across every room
+	globalBucket = data.Bucket{Rate: 2, Burst: 10}
+)
+
+// Clients going over the rate limit maxViolations times within violationWindow
+// are disconnected.
+const (
+	maxViolations   = 5
+	violationWindow = time.Minute
+)
+
+// violations counts the rate limit violations of a connection.
+type violations struct {
+	count int
+	since time.Time
+}
+
+// limit takes a token for a frame the client wants to send to the room. It
+// reports whether the frame may go on, answering the client itself when it may
+// not, and whether the client broke the limit so often it was disconnected.
+func (client *Client) limit(id string, v *violations) (bool, bool) {
+	if client.User.IsAnonymous() {
+		return true, false
+	}
+
+	wait, err := client.Server.models.RateLimit.Take(client.RoomID, client.User.ID, roomBucket, globalBucket)
+	if err != nil {
+		// Let the frame through rather than stop everyone chatting when Redis is down
+		client.Logger.Error(err.Error(), "room_id", client.RoomID)
+		return true, false
+	}
+	if wait == 0 {
+		return true, false
+	}
+
+	if time.Since(v.since) > violationWindow {
+		v.count, v.since = 0, time.Now()
+	}
+	v.count++
+	if v.count >= maxViolations {
+		client.close(websocket.StatusPolicyViolation, "rate limit exceeded")
+		client.Server.Unregister <- client
+		return false, true
+	}
+
+	client.push(rateLimitFrame(id, wait))
+	return false, false
+}
+
+func rateLimitFrame(id string, wait time.Duration) *Frame {
+	frame, _ := newFrame(FrameError, id, ErrorPayload{
+		Code:       CodeRateLimited,
+		Message:    fmt.Sprintf("you are sending messages too fast, try again in %.1f seconds", wait.Seconds()),
+		RetryAfter: wait.Milliseconds(),
+	})
+	return frame
+}
@@ -0,0 +1,477 @@
+package chat
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/automod"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/coder/websocket"
+	"sync"
+	"time"
+)
+
+// maxReplay caps how many missed messages are replayed to a reconnecting client,
+// leaving room in its buffer for live messages.
+const maxReplay = 100
+
+// viewersInterval is how often each node refreshes the viewer count of its rooms
+// and renews its presence in them, well within the presence TTL.
+const viewersInterval = 15 * time.Second
+
+// maxBacklog caps how many messages and frames may wait for a room. Past it
+// they are shed rather than queued, so a flooded room can't take the node's
+// memory with it.
+const maxBacklog = 1024
+
+type opKind int
+
+const (
+	opRegister opKind = iota
+	opUnregister
+	opBroadcast
+	opDeliver
+	opTyping
+	opReload
+	opKick
+	opClose
+	opFollow
+	opRevoke
+)
+
+type roomOp struct {
+	kind       opKind
+	client     *Client
+	message    *data.Message
+	frame      *Frame
+	nonce      string
+	userID     int64
+	moderators bool
+}
+
+// history is what a client is sent when it joins the room, moderators get the
+// room's held messages as well.
+type history struct {
+	client   *Client
+	messages []*data.Message
+	held     []*data.HeldMessage
+	err      error
+}
+
+type member struct {
+	loading bool
+	pending []*Frame
+}
+
+// room owns the clients of a single chat room. Every change to its state happens
+// on its own goroutine, so a busy room never holds up any other room.
+type room struct {
+	id       int64
+	server   *Server
+	clients  map[*Client]*member
+	wake     chan struct{}
+	loaded   chan history
+	counted  chan int
+	followed chan followState
+	done     chan struct{}
+
+	// queue holds the ops posted to the room and not yet handled, it is the
+	// only room state touched outside of the room's goroutine
+	mu      sync.Mutex
+	queue   []roomOp
+	backlog int
+	stopped bool
+
+	owner      int64
+	moderators map[int64]bool
+	history    data.HistorySettings
+	modes      data.ChatModes
+	filter     *automod.Filter
+	bans       map[int64]*data.Ban
+	viewers    int
+
+	// follows holds whether the users in the room follow it, nil while unknown
+	// or not following
+	follows map[int64]*time.Time
+
+	// size is the number of registered clients, it is only touched by Server.Run
+	size int
+}
+
+func newRoom(server *Server, id int64) *room {
+	return &room{
+		id:       id,
+		server:   server,
+		clients:  make(map[*Client]*member),
+		wake:     make(chan struct{}, 1),
+		loaded:   make(chan history),
+		counted:  make(chan int),
+		followed: make(chan followState),
+		done:     make(chan struct{}),
+		follows:  make(map[int64]*time.Time),
+	}
+}
+
+func (r *room) run() {
+	defer close(r.done)
+	defer r.server.forgetIDs(r.id)
+
+	r.reload()
+
+	ticker := time.NewTicker(viewersInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.wake:
+			ops, stopped := r.take()
+			for _, op := range ops {
+				r.handle(op)
+			}
+			if stopped {
+				return
+			}
+		case h := <-r.loaded:
+			r.replay(h)
+		case f := <-r.followed:
+			r.follows[f.userID] = f.followedAt
+		case <-ticker.C:
+			go r.count()
+		case viewers := <-r.counted:
+			if viewers == r.viewers {
+				continue
+			}
+			r.viewers = viewers
+			frame, err := newFrame(FramePresence, "", PresencePayload{Action: PresenceViewers, Viewers: viewers})
+			if err != nil {
+				r.server.logger.Error(err.Error(), "room_id", r.id)
+				continue
+			}
+			for client := range r.clients {
+				r.send(client, frame)
+			}
+		}
+	}
+}
+
+// handle applies one op to the room, on the room's goroutine.
+func (r *room) handle(op roomOp) {
+	switch op.kind {
+	case opRegister:
+		r.register(op.client)
+	case opUnregister:
+		delete(r.clients, op.client)
+		op.client.closeSend()
+		go r.server.leave(op.client)
+	case opBroadcast:
+		shadowed := op.client != nil && r.shadowed(op.client.User.ID)
+		if op.client != nil {
+			if ban, ok := r.bans[op.client.User.ID]; ok && !ban.Shadow && ban.Active() {
+				r.send(op.client, banFrame(op.nonce, ban))
+				return
+			}
+			if !r.enforceModes(op.client, op.nonce, op.message) || !r.screen(op.client, op.nonce, op.message) || !r.vet(op.client, op.nonce, op.message) || !r.cooldown(op.client, op.nonce) {
+				return
+			}
+		}
+		op.message.ID = r.server.nextID(r.id)
+
+		var err error
+		switch {
+		case shadowed:
+			err = r.server.shadow(op.message, r.history)
+		default:
+			err = r.server.store(op.message, r.history)
+		}
+		if err != nil {
+			r.server.logger.Error(err.Error(), "room_id", r.id)
+		}
+		if op.client != nil {
+			r.acknowledge(op.client, op.nonce, op.message, err)
+		}
+	case opDeliver:
+		frame := op.frame
+		if op.message != nil {
+			r.server.seenID(r.id, op.message.ID)
+			var err error
+			if frame, err = messageFrame(op.message); err != nil {
+				r.server.logger.Error(err.Error(), "room_id", r.id)
+				return
+			}
+		}
+		for client := range r.clients {
+			if op.moderators && !r.isModerator(client.User.ID) {
+				continue
+			}
+			if op.userID == 0 || client.User.ID == op.userID {
+				r.send(client, frame)
+			}
+		}
+	case opTyping:
+		r.typing(op.client)
+	case opReload:
+		r.reload()
+	case opFollow:
+		go r.lookupFollow(op.userID)
+	case opRevoke:
+		if _, ok := r.clients[op.client]; ok {
+			r.revoke(op.client)
+		}
+	case opKick:
+		for client := range r.clients {
+			if client.User.ID == op.userID {
+				r.kick(client)
+			}
+		}
+	case opClose:
+		for client := range r.clients {
+			r.remove(client)
+			go client.close(websocket.StatusGoingAway, "this channel has been deleted")
+		}
+	}
+}
+
+// post queues the op for the room without ever blocking the caller, which is
+// Server.Run. Messages and frames are shed once the room's backlog is full and
+// post reports false, every other op is always queued so the room's members
+// stay right.
+func (r *room) post(op roomOp) bool {
+	r.mu.Lock()
+	shed := op.kind == opBroadcast || op.kind == opDeliver || op.kind == opTyping
+	if shed && r.backlog >= maxBacklog {
+		r.mu.Unlock()
+		return false
+	}
+	if shed {
+		r.backlog++
+	}
+	r.queue = append(r.queue, op)
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// stop makes the room return once it has handled the ops already posted.
+func (r *room) stop() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take hands the room every op posted since it last looked.
+func (r *room) take() ([]roomOp, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ops := r.queue
+	r.queue = nil
+	r.backlog = 0
+	return ops, r.stopped
+}
+
+func (r *room) reload() {
+	channel, err := r.server.models.Channel.GetExistingChannel(r.id)
+	switch {
+	case err != nil:
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		if r.history.Limit == 0 {
+			r.history = data.DefaultHistorySettings
+		}
+	default:
+		r.owner = channel.UserID
+		r.history = channel.History
+		r.modes = channel.Modes
+		r.filter = nil
+		if channel.Automod != nil {
+			if r.filter, err = automod.New(*channel.Automod); err != nil {
+				r.server.logger.Error(err.Error(), "room_id", r.id)
+			}
+		}
+	}
+
+	// Keep the moderators and bans already loaded if they can't be refreshed
+	moderators, err := r.server.models.Moderation.GetModerators(r.id)
+	if err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+	} else {
+		r.moderators = make(map[int64]bool, len(moderators))
+		for _, moderator := range moderators {
+			r.moderators[moderator.UserID] = true
+		}
+	}
+
+	bans, err := r.server.models.Moderation.GetBans(r.id)
+	if err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		return
+	}
+	r.bans = make(map[int64]*data.Ban, len(bans))
+	for _, ban := range bans {
+		r.bans[ban.UserID] = ban
+	}
+}
+
+// isModerator reports whether the user owns the room's channel or is one of its
+// moderators, as of the room's last reload.
+func (r *room) isModerator(userID int64) bool {
+	return userID == r.owner || r.moderators[userID]
+}
+
+// count refreshes this node's presence in the room and the room's viewer count.
+func (r *room) count() {
+	if err := r.server.models.Presence.Heartbeat(r.id); err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+	}
+
+	viewers, err := r.server.models.Presence.Viewers(r.id)
+	if err != nil {
+		r.server.logger.Error(err.Error(), "room_id", r.id)
+		return
+	}
+	select {
+	case r.counted <- viewers:
+	case <-r.done:
+	}
+}
+
+func (r *room) register(client *Client) {
+	r.clients[client] = &member{loading: true}
+	go r.server.join(client)
+
+	if _, ok := r.follows[client.User.ID]; !ok && !client.User.IsAnonymous() {
+		r.follows[client.User.ID] = nil
+		go r.lookupFollow(client.User.ID)
+	}
+
+	// Load the message history off the room goroutine, live messages are queued
+	// for the client until the history has been sent.
+	replay := r.history.Replay
+	shadowed := r.shadowed(client.User.ID)
+	moderator := !client.User.IsAnonymous() && r.isModerator(client.User.ID)
+	go func() {
+		var (
+			messages []*data.Message
+			held     []*data.HeldMessage
+			err      error
+		)
+		switch {
+		case client.LastMessageID > 0:
+			// Only what the client missed since it was last connected
+			messages, err = r.server.models.Message.GetAfter(r.id, client.LastMessageID, maxReplay)
+		default:
+			messages, err = r.server.models.Message.Get(r.id, replay)
+		}
+		if shadowed && err == nil {
+			messages, err = r.server.withShadowed(client, messages)
+		}
+		if moderator && err == nil {
+			held, err = r.server.models.HeldMessage.GetHeld(r.id)
+		}
+		select {
+		case r.loaded <- history{client: client, messages: messages, held: held, err: err}:
+		case <-r.done:
+		}
+	}()
+}
+
+func (r *room) replay(h history) {
+	m, ok := r.clients[h.client]
+	if !ok {
+		return
+	}
+	if h.err != nil {
+		r.server.logger.Error(h.err.Error(), "room_id", r.id)
+	}
+
+	frames := make([]*Frame, 0, len(h.messages)+len(h.held)+len(m.pending))
+	for _, message := range h.messages {
+		frame, err := messageFrame(message)
+		if err != nil {
+			r.server.logger.Error(err.Error(), "room_id", r.id)
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	for _, held := range h.held {
+		frame, err := newFrame(FrameHeld, "", held)
+		if err != nil {
+			r.server.logger.Error(err.Error(), "room_id", r.id)
+			continue
+		}
+		frames = append(frames, frame)
+	}
+
+	m.loading = false
+	for _, frame := range append(frames, m.pending...) {
+		if !r.send(h.client, frame) {
+			return
+		}
+	}
+	m.pending = nil
+}
+
+// acknowledge tells the client that sent the message whether it made it.
+func (r *room) acknowledge(client *Client, nonce string, message *data.Message, err error) {
+	var frame *Frame
+	switch {
+	case errors.Is(err, errNotPersisted):
+		frame = errorFrame(nonce, CodePersistenceFailed, "the message could not be saved, please try again")
+	case errors.Is(err, errNotPublished):
+		frame = errorFrame(nonce, CodeDeliveryFailed, "the message was saved but could not be delivered to the room")
+	default:
+		frame, err = newFrame(FrameAck, nonce, AckPayload{MessageID: message.ID})
+		if err != nil {
+			r.server.logger.Error(err.Error(), "room_id", r.id)
+			return
+		}
+	}
+	r.send(client, frame)
+}
+
+func (r *room) send(client *Client, frame *Frame) bool {
+	m, ok := r.clients[client]
+	if !ok {
+		return false
+	}
+
+	if m.loading {
+		if len(m.pending) == cap(client.Send) {
+			r.drop(client)
+			return false
+		}
+		m.pending = append(m.pending, frame)
+		return true
+	}
+
+	if !client.push(frame) {
+		r.drop(client)
+		return false
+	}
+	return true
+}
+
+func (r *room) drop(client *Client) {
+	r.remove(client)
+	go client.CloseSlow()
+}
+
+// kick ends the session of a banned client, it can't be resumed.
+func (r *room) kick(client *Client) {
+	r.remove(client)
+	go client.close(websocket.StatusPolicyViolation, "you have been banned from this channel")
+}
+
+func (r *room) remove(client *Client) {
+	delete(r.clients, client)
+	client.end()
+	go func() {
+		r.server.Unregister <- client
+	}()
+}
@@ -1,96 +1,491 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/coder/websocket"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
-type room struct {
-	mu      sync.RWMutex
-	clients map[*Client]struct{}
+const (
+	eventMessage = "message"
+	eventFrame   = "frame"
+	eventReload  = "reload"
+	eventKick    = "kick"
+	eventClose   = "close"
+	eventFollow  = "follow"
+)
+
+// presenceDebounce is how long a user has to be gone before their leave is
+// announced, so flapping connections don't spam the room.
+const presenceDebounce = 10 * time.Second
+
+// event is what nodes publish to each other on a room's Redis channel. Frames
+// are ephemeral and only delivered to whoever is in the room at the time, or
+// only to the connections of UserID when it is set, or only to the moderators
+// of the room when Moderators is set.
+type event struct {
+	Kind       string        `json:"kind"`
+	Message    *data.Message `json:"message,omitempty"`
+	Frame      *Frame        `json:"frame,omitempty"`
+	UserID     int64         `json:"user_id,omitempty"`
+	Moderators bool          `json:"moderators,omitempty"`
+}
+
+var (
+	errNotPersisted = errors.New("message could not be persisted")
+	errNotPublished = errors.New("message could not be published")
+)
+
+// submission is a message sent by a client over its websocket, which gets
+// acknowledged once the room has stored and published it. A typing submission
+// carries no message and is never acknowledged.
+type submission struct {
+	client  *Client
+	nonce   string
+	message *data.Message
+	typing  bool
 }
 
 type Server struct {
 	Register   chan *Client
 	Unregister chan *Client
 	Broadcast  chan *data.Message
+	submit     chan *submission
+
+	rooms   map[int64]*room
+	members map[*Client]*room
+	models  data.Models
+	logger  *slog.Logger
+	redisDB *redis.Client
+	pubsub  *redis.PubSub
+	ids     *idGenerator
 
-	mu     sync.RWMutex
-	rooms  map[int64]*room
-	models data.Models
+	mu       sync.Mutex
+	sessions map[string]*Client
+
+	// lastIDs holds the newest message ID seen in each room on this node, IDs
+	// handed out for a room never go below it
+	idsMu   sync.Mutex
+	lastIDs map[int64]int64
 }
 
-func NewServer(models data.Models) *Server {
+func NewServer(models data.Models, redisDB *redis.Client, logger *slog.Logger) *Server {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Every node takes its own node number so message IDs never collide
+	node, err := redisDB.Incr(ctx, "chat:nodes").Result()
+	if err != nil {
+		logger.Error(err.Error())
+		node = rand.Int64()
+	}
+
 	return &Server{
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan *data.Message),
+		submit:     make(chan *submission),
 		rooms:      make(map[int64]*room),
+		members:    make(map[*Client]*room),
 		models:     models,
+		logger:     logger,
+		redisDB:    redisDB,
+		pubsub:     redisDB.Subscribe(context.Background(), revokedChannel),
+		ids:        newIDGenerator(node),
+		sessions:   make(map[string]*Client),
+		lastIDs:    make(map[int64]int64),
 	}
 }
 
+func roomChannel(roomID int64) string {
+	return "room:" + strconv.FormatInt(roomID, 10) + ":broadcast"
+}
+
+func roomIDFromChannel(channel string) (int64, error) {
+	channel = strings.TrimPrefix(channel, "room:")
+	channel = strings.TrimSuffix(channel, ":broadcast")
+	return strconv.ParseInt(channel, 10, 64)
+}
+
+// Run routes clients and messages to the goroutine of the room they belong to.
+// Run itself holds no room state, so it never waits on a single room's work.
 func (server *Server) Run() {
+	// Messages published by every node for the rooms this node has clients in
+	published := server.pubsub.Channel()
+
 	for {
 		select {
 		case client := <-server.Register:
 			// Handle room creation
-			server.mu.Lock()
-			if _, ok := server.rooms[client.RoomID]; !ok {
-				server.rooms[client.RoomID] = &room{
-					clients: make(map[*Client]struct{}),
-				}
-			}
-			server.mu.Unlock()
-
-			room := server.rooms[client.RoomID]
-			room.mu.Lock()
-			// Add client to room
-			room.clients[client] = struct{}{}
-			room.mu.Unlock()
-
-			// Get message history
-			messages, _ := server.models.Message.Get(client.RoomID)
-			// Send message history
-			for _, message := range messages {
-				select {
-				case client.Message <- message:
-				default:
-					server.Unregister <- client
-					go client.CloseSlow()
-				}
+			r, ok := server.rooms[client.RoomID]
+			if !ok {
+				r = newRoom(server, client.RoomID)
+				server.rooms[client.RoomID] = r
+				server.subscribe(client.RoomID)
+				go r.run()
 			}
+			server.members[client] = r
+			r.size++
+			r.post(roomOp{kind: opRegister, client: client})
 		case client := <-server.Unregister:
-			if room, ok := server.rooms[client.RoomID]; ok {
-				room.mu.Lock()
-				// Remove client from room
-				delete(room.clients, client)
-				// Remove empty room
-				if len(room.clients) == 0 {
-					server.mu.Lock()
-					delete(server.rooms, client.RoomID)
-					server.mu.Unlock()
-				}
-				close(client.Message)
-				room.mu.Unlock()
+			r, ok := server.members[client]
+			if !ok {
+				continue
+			}
+			delete(server.members, client)
+			server.untrack(client)
+			client.end()
+			r.size--
+			r.post(roomOp{kind: opUnregister, client: client})
+
+			// Remove empty room
+			if r.size == 0 {
+				delete(server.rooms, r.id)
+				server.unsubscribe(r.id)
+				r.stop()
 			}
 		case message := <-server.Broadcast:
-			if room, ok := server.rooms[message.RoomID]; ok {
-				// Add message to message history
-				_ = server.models.Message.Set(message)
-
-				room.mu.RLock()
-				// Send message to all clients
-				for client := range room.clients {
-					select {
-					case client.Message <- message:
-					default:
-						server.Unregister <- client
-						go client.CloseSlow()
-					}
+			if r, ok := server.rooms[message.RoomID]; ok {
+				if !r.post(roomOp{kind: opBroadcast, message: message}) {
+					server.overflow(message)
 				}
-				room.mu.RUnlock()
+				continue
 			}
+			server.overflow(message)
+		case sub := <-server.submit:
+			// The client may have left the room while the message was on its way
+			if r, ok := server.members[sub.client]; ok {
+				if sub.typing {
+					r.post(roomOp{kind: opTyping, client: sub.client})
+					continue
+				}
+				if !r.post(roomOp{kind: opBroadcast, message: sub.message, client: sub.client, nonce: sub.nonce}) {
+					sub.client.push(errorFrame(sub.nonce, CodeRoomBusy, "the room is too busy right now, please try again"))
+				}
+			}
+		case msg, ok := <-published:
+			if !ok {
+				return
+			}
+			if msg.Channel == revokedChannel {
+				server.revoked(msg)
+				continue
+			}
+			roomID, evt, err := decode(msg)
+			if err != nil {
+				server.logger.Error(err.Error(), "channel", msg.Channel)
+				continue
+			}
+			server.dispatch(roomID, evt)
+		}
+	}
+}
+
+// dispatch hands an event published for the room to the room, if this node has
+// it. Only Run calls it.
+func (server *Server) dispatch(roomID int64, evt *event) {
+	r, ok := server.rooms[roomID]
+	if !ok {
+		return
+	}
+
+	var op roomOp
+	switch evt.Kind {
+	case eventMessage:
+		if evt.Message == nil {
+			return
+		}
+		op = roomOp{kind: opDeliver, message: evt.Message}
+	case eventFrame:
+		if evt.Frame == nil {
+			return
+		}
+		op = roomOp{kind: opDeliver, frame: evt.Frame, userID: evt.UserID, moderators: evt.Moderators}
+	case eventReload:
+		op = roomOp{kind: opReload}
+	case eventKick:
+		op = roomOp{kind: opKick, userID: evt.UserID}
+	case eventClose:
+		op = roomOp{kind: opClose}
+	case eventFollow:
+		op = roomOp{kind: opFollow, userID: evt.UserID}
+	default:
+		return
+	}
+
+	// The room is too far behind, its clients catch up on the history when
+	// they reconnect
+	if !r.post(op) {
+		server.logger.Warn("room backlog full, dropping event", "room_id", roomID, "kind", evt.Kind)
+	}
+}
+
+// overflow stores a message sent by the server itself without going through
+// the room, for when the room isn't on this node or is too busy to take it.
+func (server *Server) overflow(message *data.Message) {
+	message.ID = server.nextID(message.RoomID)
+	go func() {
+		if err := server.store(message, server.settings(message.RoomID)); err != nil {
+			server.logger.Error(err.Error(), "room_id", message.RoomID)
+		}
+	}()
+}
+
+// nextID hands out a message ID for the room, greater than every ID seen in the
+// room on this node. Every message gets its ID here, whether or not it goes
+// through the room's goroutine, so the room's IDs stay in order for the history
+// and resume cursors.
+func (server *Server) nextID(roomID int64) int64 {
+	server.idsMu.Lock()
+	defer server.idsMu.Unlock()
+
+	id := server.ids.Next(server.lastIDs[roomID])
+	server.lastIDs[roomID] = id
+	return id
+}
+
+// seenID raises the floor of the room's IDs to an ID handed out by another node.
+func (server *Server) seenID(roomID, id int64) {
+	server.idsMu.Lock()
+	defer server.idsMu.Unlock()
+
+	server.lastIDs[roomID] = max(server.lastIDs[roomID], id)
+}
+
+// forgetIDs drops the floor of a room that has left the node.
+func (server *Server) forgetIDs(roomID int64) {
+	server.idsMu.Lock()
+	defer server.idsMu.Unlock()
+
+	delete(server.lastIDs, roomID)
+}
+
+func (server *Server) subscribe(roomID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := server.pubsub.Subscribe(ctx, roomChannel(roomID)); err != nil {
+		server.logger.Error(err.Error(), "room_id", roomID)
+	}
+}
+
+func (server *Server) unsubscribe(roomID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := server.pubsub.Unsubscribe(ctx, roomChannel(roomID)); err != nil {
+		server.logger.Error(err.Error(), "room_id", roomID)
+	}
+}
+
+// Resume reattaches the session of the resume token to conn, as long as it is
+// still within its grace period on this node, belongs to the room and belongs
+// to user. Sessions are only kept on the node they were started on, a client
+// that reconnects to another node gets a new session and catches up through
+// its last message ID instead.
+func (server *Server) Resume(token string, roomID int64, user *data.User, conn *websocket.Conn) (*Client, bool) {
+	server.mu.Lock()
+	client, ok := server.sessions[token]
+	server.mu.Unlock()
+	if !ok || client.RoomID != roomID {
+		return nil, false
+	}
+
+	// Only whoever started the session may take it over, an anonymous caller
+	// can only resume an anonymous session
+	if client.User.IsAnonymous() != user.IsAnonymous() || client.User.ID != user.ID {
+		return nil, false
+	}
+
+	client.mu.Lock()
+	if client.closed {
+		client.mu.Unlock()
+		return nil, false
+	}
+	if client.grace != nil {
+		client.grace.Stop()
+		client.grace = nil
+	}
+	old := client.Conn
+	client.Conn = conn
+	client.mu.Unlock()
+
+	// The old connection may only be half open, close it so its writer lets go
+	if old != nil {
+		_ = old.CloseNow()
+	}
+	return client, true
+}
+
+func (server *Server) track(token string, client *Client) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	// The client may already have left the room before it got a connection
+	if !client.closed {
+		server.sessions[token] = client
+	}
+}
+
+func (server *Server) untrack(client *Client) {
+	client.mu.Lock()
+	token := client.resumeToken
+	client.mu.Unlock()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	delete(server.sessions, token)
+}
+
+// detach starts the grace period of the session once conn, its current
+// connection, is gone. The client leaves the room if it doesn't resume in time.
+func (server *Server) detach(client *Client, conn *websocket.Conn) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.closed || client.Conn != conn {
+		return
+	}
+	client.grace = time.AfterFunc(resumeGrace, func() {
+		server.Unregister <- client
+	})
+}
+
+// Reload makes every node reload the settings, modes, moderators and bans of the
+// room, for when the channel has been changed.
+func (server *Server) Reload(roomID int64) error {
+	return server.publish(roomID, &event{Kind: eventReload})
+}
+
+// Deleted disconnects everyone in the room on every node, for when its channel
+// has been deleted.
+func (server *Server) Deleted(roomID int64) error {
+	return server.publish(roomID, &event{Kind: eventClose})
+}
+
+// Notice sends a system notice to everyone in the room.
+func (server *Server) Notice(roomID int64, text string) error {
+	return server.publishFrame(roomID, FrameNotice, NoticePayload{Text: text})
+}
+
+func (server *Server) publishFrame(roomID int64, frameType string, payload any) error {
+	frame, err := newFrame(frameType, "", payload)
+	if err != nil {
+		return err
+	}
+	return server.publish(roomID, &event{Kind: eventFrame, Frame: frame})
+}
+
+// join counts the client as present in the room and announces the user if this
+// is their first connection.
+func (server *Server) join(client *Client) {
+	announce, err := server.models.Presence.Join(client.RoomID, client.User)
+	if err != nil {
+		server.logger.Error(err.Error(), "room_id", client.RoomID)
+		return
+	}
+	if announce {
+		server.announce(client, PresenceJoin)
+	}
+}
+
+// leave removes the client from the room's presence and, once the debounce has
+// passed without the user coming back, announces that the user left.
+func (server *Server) leave(client *Client) {
+	last, err := server.models.Presence.Leave(client.RoomID, client.User, presenceDebounce)
+	if err != nil {
+		server.logger.Error(err.Error(), "room_id", client.RoomID)
+		return
+	}
+	if !last {
+		return
+	}
+
+	time.AfterFunc(presenceDebounce, func() {
+		left, err := server.models.Presence.Left(client.RoomID, client.User)
+		if err != nil {
+			server.logger.Error(err.Error(), "room_id", client.RoomID)
+			return
 		}
+		if left {
+			server.announce(client, PresenceLeave)
+		}
+	})
+}
+
+func (server *Server) announce(client *Client, action string) {
+	viewers, err := server.models.Presence.Viewers(client.RoomID)
+	if err != nil {
+		server.logger.Error(err.Error(), "room_id", client.RoomID)
+	}
+
+	payload := PresencePayload{Action: action, UserID: client.User.ID, Username: client.User.Name, Viewers: viewers}
+	if err := server.publishFrame(client.RoomID, FramePresence, payload); err != nil {
+		server.logger.Error(err.Error(), "room_id", client.RoomID)
+	}
+}
+
+// settings returns the history settings of the room, falling back to the
+// defaults when the channel can't be loaded.
+func (server *Server) settings(roomID int64) data.HistorySettings {
+	channel, err := server.models.Channel.GetExistingChannel(roomID)
+	if err != nil {
+		server.logger.Error(err.Error(), "room_id", roomID)
+		return data.DefaultHistorySettings
+	}
+	return channel.History
+}
+
+// store adds the message to the message history and publishes it to every node
+// subscribed to the room.
+func (server *Server) store(message *data.Message, history data.HistorySettings) error {
+	if err := server.models.Message.Set(message, history); err != nil {
+		return fmt.Errorf("%w: %w", errNotPersisted, err)
+	}
+
+	if err := server.publish(message.RoomID, &event{Kind: eventMessage, Message: message}); err != nil {
+		return fmt.Errorf("%w: %w", errNotPublished, err)
+	}
+	return nil
+}
+
+func (server *Server) publish(roomID int64, evt *event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	msg, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	return server.redisDB.Publish(ctx, roomChannel(roomID), msg).Err()
+}
+
+func decode(msg *redis.Message) (int64, *event, error) {
+	roomID, err := roomIDFromChannel(msg.Channel)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var evt event
+	if err := json.Unmarshal([]byte(msg.Payload), &evt); err != nil {
+		return 0, nil, err
+	}
+	if evt.Message != nil {
+		evt.Message.RoomID = roomID
 	}
+	return roomID, &evt, nil
 }
@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"fmt"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestServer(rooms int) *Server {
+	server := &Server{
+		rooms:   make(map[int64]*room, rooms),
+		members: make(map[*Client]*room),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		ids:     newIDGenerator(1),
+		lastIDs: make(map[int64]int64),
+	}
+	for id := int64(1); id <= int64(rooms); id++ {
+		server.rooms[id] = newRoom(server, id)
+	}
+	return server
+}
+
+// drain stands in for a room's goroutine, it only records how long each
+// message waited to be handled.
+func drain(r *room, wg *sync.WaitGroup, handled *atomic.Int64, waited *atomic.Int64) {
+	defer wg.Done()
+	for range r.wake {
+		ops, stopped := r.take()
+		for _, op := range ops {
+			waited.Add(int64(time.Since(op.message.Timestamp)))
+			handled.Add(1)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+func TestDispatchDoesNotBlockOnStalledRoom(t *testing.T) {
+	server := newTestServer(1)
+	r := server.rooms[1]
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*maxBacklog; i++ {
+			server.dispatch(1, &event{Kind: eventMessage, Message: &data.Message{RoomID: 1}})
+		}
+		server.dispatch(1, &event{Kind: eventReload})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked on a room that isn't handling its ops")
+	}
+
+	ops, _ := r.take()
+	if got, want := len(ops), maxBacklog+1; got != want {
+		t.Fatalf("got %d queued ops, want %d", got, want)
+	}
+	if ops[len(ops)-1].kind != opReload {
+		t.Fatal("reload was shed along with the messages")
+	}
+}
+
+// BenchmarkBroadcast routes messages round robin over thousands of rooms, one
+// of which never handles its ops, and reports how long messages waited for the
+// other rooms to pick them up.
+func BenchmarkBroadcast(b *testing.B) {
+	for _, rooms := range []int{1_000, 10_000} {
+		b.Run(fmt.Sprintf("rooms=%d", rooms), func(b *testing.B) {
+			server := newTestServer(rooms)
+
+			var (
+				wg      sync.WaitGroup
+				handled atomic.Int64
+				waited  atomic.Int64
+			)
+			// Room 1 is stalled, everything it is sent past its backlog is shed
+			for id := int64(2); id <= int64(rooms); id++ {
+				wg.Add(1)
+				go drain(server.rooms[id], &wg, &handled, &waited)
+			}
+
+			var stalled int64
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				roomID := int64(i%rooms) + 1
+				if roomID == 1 {
+					stalled++
+				}
+				server.dispatch(roomID, &event{Kind: eventMessage, Message: &data.Message{RoomID: roomID, Timestamp: time.Now()}})
+			}
+			for handled.Load() < int64(b.N)-stalled {
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+
+			for id := int64(2); id <= int64(rooms); id++ {
+				server.rooms[id].stop()
+			}
+			wg.Wait()
+
+			if n := handled.Load(); n > 0 {
+				b.ReportMetric(float64(waited.Load())/float64(n), "ns/delivery")
+			}
+		})
+	}
+}
+
+func TestNextIDStaysAboveIDsSeenInRoom(t *testing.T) {
+	server := newTestServer(0)
+
+	// A node with its clock an hour ahead delivered the newest message of room 1
+	ahead := (time.Since(epoch).Milliseconds() + time.Hour.Milliseconds()) << timeShift
+	server.seenID(1, ahead)
+
+	// Messages that skip the room's goroutine, like server messages and shadowed
+	// ones, go through nextID all the same
+	if id := server.nextID(1); id <= ahead {
+		t.Fatalf("ID %d is not above %d, the newest ID seen in the room", id, ahead)
+	}
+
+	server.forgetIDs(1)
+	if _, ok := server.lastIDs[1]; ok {
+		t.Fatal("floor of a room that left the node was kept")
+	}
+}
@@ -0,0 +1,56 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/coder/websocket"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// revokedChannel is where nodes tell each other about login sessions that have
+// been logged out, every node is subscribed to it.
+const revokedChannel = "sessions:revoked"
+
+// Revoke disconnects the clients of the login sessions on every node.
+func (server *Server) Revoke(sessionIDs ...string) error {
+	if len(sessionIDs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	msg, err := json.Marshal(sessionIDs)
+	if err != nil {
+		return err
+	}
+	return server.redisDB.Publish(ctx, revokedChannel, msg).Err()
+}
+
+// revoked hands the clients of the revoked sessions on this node to their rooms
+// to be disconnected. It is only called by Run.
+func (server *Server) revoked(msg *redis.Message) {
+	var sessionIDs []string
+	if err := json.Unmarshal([]byte(msg.Payload), &sessionIDs); err != nil {
+		server.logger.Error(err.Error(), "channel", msg.Channel)
+		return
+	}
+
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		revoked[sessionID] = true
+	}
+	for client, r := range server.members {
+		if client.SessionID != "" && revoked[client.SessionID] {
+			r.post(roomOp{kind: opRevoke, client: client})
+		}
+	}
+}
+
+// revoke ends the session of a client whose login session was logged out, it
+// can't be resumed.
+func (r *room) revoke(client *Client) {
+	r.remove(client)
+	go client.close(websocket.StatusPolicyViolation, "your session has been logged out")
+}
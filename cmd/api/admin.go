@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"net/http"
+	"time"
+)
+
+// outranks reports whether the user of the request may act on the target user,
+// which is only when the target lacks some permission the user has. Staff can't
+// act on admins, nor admins on each other. It sends the error response if not.
+func (app *application) outranks(w http.ResponseWriter, r *http.Request, targetID int64) bool {
+	permissions, err := app.models.Permission.GetAllForUser(targetID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+	if permissions.Covers(app.contextGetUser(r).Permissions) {
+		app.notPermittedResponse(w, r)
+		return false
+	}
+	return true
+}
+
+// suspendUserHandler keeps a user from logging in and logs them out everywhere,
+// indefinitely when no duration is given.
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason   string `json:"reason"`
+		Duration int    `json:"duration"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	admin := app.contextGetUser(r)
+	suspension := &data.Suspension{UserID: userID, Reason: input.Reason, CreatedBy: admin.ID}
+	if input.Duration != 0 {
+		expiresAt := time.Now().Add(time.Duration(input.Duration) * time.Second)
+		suspension.ExpiresAt = &expiresAt
+	}
+
+	v := validator.New()
+	v.Check(userID != admin.ID, "user_id", "cannot suspend yourself")
+	if data.ValidateSuspension(v, suspension); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.outranks(w, r, userID) {
+		return
+	}
+
+	if err := app.models.Suspension.Suspend(suspension); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	sessionIDs, err := app.models.SessionToken.DeleteAllForUser(userID, "")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.chatServer.Revoke(sessionIDs...); err != nil {
+		app.logError(r, err)
+	}
+
+	app.audit(r, data.AuditSite, data.AuditUserSuspend, data.TargetUser, userID, nil, suspension)
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"suspension": suspension}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	suspension, err := app.models.Suspension.Get(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Suspension.Unsuspend(userID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, data.AuditSite, data.AuditUserUnsuspend, data.TargetUser, userID, suspension, nil)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "suspension lifted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	roles, err := app.models.Permission.GetRolesForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"roles": roles}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserRolesHandler replaces the roles of a user, the new permissions take
+// effect in the user's sessions straight away.
+func (app *application) updateUserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Roles []string `json:"roles"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	// Admins can't lock themselves out by accident
+	v.Check(userID != app.contextGetUser(r).ID, "user_id", "cannot change your own roles")
+	if data.ValidateRoles(v, input.Roles); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.outranks(w, r, userID) {
+		return
+	}
+
+	roles, err := app.models.Permission.GetRolesForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.models.Permission.SetRolesForUser(userID, input.Roles); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	permissions, err := app.models.Permission.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.models.SessionToken.RefreshPermissions(userID, permissions); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.audit(r, data.AuditSite, data.AuditUserRoles, data.TargetUser, userID, envelope{"roles": roles}, envelope{"roles": input.Roles})
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"roles": input.Roles, "permissions": permissions}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// forceDeleteChannelHandler deletes any channel, whoever owns it.
+func (app *application) forceDeleteChannelHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	channel, err := app.models.Channel.GetExistingChannel(channelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Channel.DeleteChannel(channel.UserID, channel.ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.chatServer.Deleted(channel.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	// A channel's log can't be read once the channel is gone, so its deletion is recorded site-wide
+	app.audit(r, data.AuditSite, data.AuditChannelDelete, data.TargetChannel, channel.ID, channel, nil)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "channel deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getChannelModerationHandler shows the moderation state of any channel: its
+// moderators, bans, held messages and open reports.
+func (app *application) getChannelModerationHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	channel, err := app.models.Channel.GetExistingChannel(channelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	moderators, err := app.models.Moderation.GetModerators(channel.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	bans, err := app.models.Moderation.GetBans(channel.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	held, err := app.models.HeldMessage.GetHeld(channel.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	reports, cursor, err := app.models.Report.GetAll(channel.ID, data.ReportFilter{Status: data.ReportOpen, Limit: 50})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	moderation := envelope{"moderators": moderators, "bans": bans, "held": held, "reports": reports, "cursor": cursor}
+	if err := app.writeJSON(w, http.StatusOK, envelope{"channel": channel, "moderation": moderation}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSiteAuditLogHandler shows the log of admin actions that belong to no
+// channel, along with every channel deleted.
+func (app *application) listSiteAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	filter := app.readAuditFilter(r, v)
+
+	if data.ValidateAuditFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, cursor, err := app.models.Audit.GetAll(data.AuditSite, filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"audit_log": entries, "cursor": cursor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
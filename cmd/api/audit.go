@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"net/http"
+)
+
+// listAuditLogHandler shows the owner the audit log of their channel. Channel
+// deletions are only in the site-wide log, as the channel is gone by then.
+func (app *application) listAuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	filter := app.readAuditFilter(r, v)
+
+	if data.ValidateAuditFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	channel, err := app.models.Channel.GetExistingChannel(channelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if channel.UserID != app.contextGetUser(r).ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	entries, cursor, err := app.models.Audit.GetAll(channel.ID, filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"audit_log": entries, "cursor": cursor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) readAuditFilter(r *http.Request, v *validator.Validator) data.AuditFilter {
+	qs := r.URL.Query()
+
+	return data.AuditFilter{
+		BeforeID: int64(app.readInt(qs, "before_id", 0, v)),
+		Action:   app.readString(qs, "action", ""),
+		ActorID:  int64(app.readInt(qs, "actor_id", 0, v)),
+		TargetID: int64(app.readInt(qs, "target_id", 0, v)),
+		Before:   app.readTime(qs, "before", v),
+		After:    app.readTime(qs, "after", v),
+		Limit:    app.readInt(qs, "limit", 50, v),
+	}
+}
@@ -34,8 +34,11 @@ func (app *application) createChannelHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	automod := data.DefaultAutomodSettings
 	channel := &data.Channel{
-		Name: input.Name,
+		Name:    input.Name,
+		History: data.DefaultHistorySettings,
+		Automod: &automod,
 	}
 
 	v := validator.New()
@@ -55,6 +58,8 @@ func (app *application) createChannelHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	app.audit(r, channel.ID, data.AuditChannelCreate, data.TargetChannel, channel.ID, nil, channel)
+
 	if err := app.writeJSON(w, http.StatusCreated, envelope{"channel": channel}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -64,8 +69,14 @@ func (app *application) editChannelHandler(w http.ResponseWriter, r *http.Reques
 	user := app.contextGetUser(r)
 
 	var input struct {
-		ID   int64  `json:"channel_id"`
-		Name string `json:"channel_name"`
+		ID      int64   `json:"channel_id"`
+		Name    *string `json:"channel_name"`
+		History *struct {
+			Limit  *int `json:"limit"`
+			MaxAge *int `json:"max_age"`
+			Replay *int `json:"replay"`
+		} `json:"history"`
+		Automod *data.AutomodSettings `json:"automod"`
 	}
 
 	if err := app.readJSON(w, r, &input); err != nil {
@@ -84,7 +95,25 @@ func (app *application) editChannelHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	channel.Name = input.Name
+	before := *channel
+
+	if input.Name != nil {
+		channel.Name = *input.Name
+	}
+	if input.History != nil {
+		if input.History.Limit != nil {
+			channel.History.Limit = *input.History.Limit
+		}
+		if input.History.MaxAge != nil {
+			channel.History.MaxAge = *input.History.MaxAge
+		}
+		if input.History.Replay != nil {
+			channel.History.Replay = *input.History.Replay
+		}
+	}
+	if input.Automod != nil {
+		channel.Automod = input.Automod
+	}
 
 	v := validator.New()
 	if data.ValidateChannel(v, channel); !v.Valid() {
@@ -92,7 +121,7 @@ func (app *application) editChannelHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if err := app.models.Channel.UpdateChannelName(user.ID, channel); err != nil {
+	if err := app.models.Channel.UpdateChannel(user.ID, channel); err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateChannel):
 			v.AddError("channel", "the user with this channel name already exists")
@@ -105,6 +134,17 @@ func (app *application) editChannelHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	app.audit(r, channel.ID, data.AuditChannelUpdate, data.TargetChannel, channel.ID, &before, channel)
+
+	if err := app.chatServer.Reload(channel.ID); err != nil {
+		app.logError(r, err)
+	}
+	if input.Name != nil {
+		if err := app.chatServer.Notice(channel.ID, "the channel has been renamed to "+channel.Name); err != nil {
+			app.logError(r, err)
+		}
+	}
+
 	if err := app.writeJSON(w, http.StatusOK, envelope{"channel": channel}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -150,6 +190,13 @@ func (app *application) deleteChannelHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if err := app.chatServer.Deleted(channel.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	// A channel's log can't be read once the channel is gone, so its deletion is recorded site-wide
+	app.audit(r, data.AuditSite, data.AuditChannelDelete, data.TargetChannel, channel.ID, channel, nil)
+
 	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "channel deleted"}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -173,16 +220,53 @@ func (app *application) getChannelHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	var websocketToken *data.SessionToken
-	if user := app.contextGetUser(r); !user.IsAnonymous() {
-		token, err := app.models.SessionToken.New(user, 3*time.Second)
+	// The automod rules are only for the owner to see
+	user := app.contextGetUser(r)
+	if user.ID != channel.UserID {
+		channel.Automod = nil
+	}
+
+	// The ticket only opens the channel's websocket, it is no good anywhere else
+	var websocketTicket *data.Ticket
+	if !user.IsAnonymous() {
+		ticket, err := app.models.Ticket.New(user, channel.ID, app.contextGetSessionID(r), 30*time.Second, data.ScopeWebsocket)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
-		websocketToken = token
+		websocketTicket = ticket
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"channel": channel, "websocket_ticket": websocketTicket}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getPresenceHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	channel, err := app.models.Channel.GetExistingChannel(channelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
 	}
 
-	if err := app.writeJSON(w, http.StatusOK, envelope{"channel": channel, "websocket_token": websocketToken}, nil); err != nil {
+	viewers, chatters, err := app.models.Presence.Get(channel.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"viewers": viewers, "chatters": chatters}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -10,6 +10,8 @@ import (
 	"net/http"
 )
 
+var errSuspended = errors.New("user suspended")
+
 func (app *application) websocketHandler(w http.ResponseWriter, r *http.Request) {
 	ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{
 		InsecureSkipVerify: true,
@@ -18,11 +20,13 @@ func (app *application) websocketHandler(w http.ResponseWriter, r *http.Request)
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	ws.SetReadLimit(768)
+	ws.SetReadLimit(4096)
 
 	var input struct {
-		SessionToken *string `json:"session_token"`
-		RoomID       int     `json:"room_id"`
+		Ticket        *string `json:"ticket"`
+		RoomID        int     `json:"room_id"`
+		LastMessageID int64   `json:"last_message_id,string"`
+		ResumeToken   *string `json:"resume_token"`
 	}
 	if err := wsjson.Read(context.Background(), ws, &input); err != nil {
 		if err := ws.Close(websocket.StatusPolicyViolation, "Requires room_id"); err != nil {
@@ -31,56 +35,118 @@ func (app *application) websocketHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if input.SessionToken != nil {
-		user, err := app.models.User.GetFromToken(*input.SessionToken)
+	channel, err := app.models.Channel.GetExistingChannel(int64(input.RoomID))
+	if err != nil {
+		var roomErr string
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			roomErr = "Invalid room_id"
+		default:
+			roomErr = "Server Error"
+		}
+		if err := ws.Close(websocket.StatusInternalError, roomErr); err != nil {
+			return
+		}
+		return
+	}
+
+	// The ticket is used up here whether or not the rest of the handshake works out
+	sessionID := app.contextGetSessionID(r)
+	if input.Ticket != nil {
+		user, ticket, err := app.consumeWebsocketTicket(*input.Ticket, channel.ID)
 		if err != nil {
-			var tokenErr string
+			var ticketErr string
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
-				tokenErr = "Invalid session_token"
+				ticketErr = "Invalid ticket"
+			case errors.Is(err, errSuspended):
+				ticketErr = "Your user account has been suspended"
 			default:
-				tokenErr = "Server Error"
+				app.logger.Error(err.Error())
+				ticketErr = "Server Error"
 			}
-			if err := ws.Close(websocket.StatusInternalError, tokenErr); err != nil {
+			if err := ws.Close(websocket.StatusPolicyViolation, ticketErr); err != nil {
 				return
 			}
 			return
 		}
 		r = app.contextSetUser(r, user)
+		sessionID = ticket.SessionID
 	}
 
-	channel, err := app.models.Channel.GetExistingChannel(int64(input.RoomID))
-	if err != nil {
-		var roomErr string
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			roomErr = "Invalid room_id"
-		default:
-			roomErr = "Server Error"
+	user := app.contextGetUser(r)
+
+	// Timed out and shadow banned users can still watch, banned users can't get in at all
+	if !user.IsAnonymous() {
+		ban, err := app.models.Moderation.GetBan(channel.ID, user.ID)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.logger.Error(err.Error())
+			if err := ws.Close(websocket.StatusInternalError, "Server Error"); err != nil {
+				return
+			}
+			return
 		}
-		if err := ws.Close(websocket.StatusInternalError, roomErr); err != nil {
+		if ban != nil && !ban.IsTimeout() && !ban.Shadow {
+			if err := ws.Close(websocket.StatusPolicyViolation, "You are banned from this channel"); err != nil {
+				return
+			}
 			return
 		}
-		return
 	}
 
-	client := &chat.Client{
-		Conn:    ws,
-		Logger:  app.logger,
-		User:    app.contextGetUser(r),
-		Message: make(chan *data.Message, 128),
-		Server:  app.chatServer,
-		RoomID:  channel.ID,
-		CloseSlow: func() {
-			if err := ws.Close(websocket.StatusPolicyViolation, "connection too slow to keep up with messages"); err != nil {
-				return
+	if input.ResumeToken != nil {
+		if client, ok := app.chatServer.Resume(*input.ResumeToken, channel.ID, user, ws); ok {
+			if err := client.Attach(ws); err != nil {
+				app.logger.Error(err.Error())
 			}
-		},
+			return
+		}
+	}
+
+	client := &chat.Client{
+		Logger:        app.logger,
+		User:          user,
+		Send:          make(chan *chat.Frame, 128),
+		Server:        app.chatServer,
+		RoomID:        channel.ID,
+		LastMessageID: input.LastMessageID,
+		SessionID:     sessionID,
 	}
 	client.Server.Register <- client
 
-	if !client.User.IsAnonymous() {
-		go client.ReadMessage()
+	if err := client.Attach(ws); err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// consumeWebsocketTicket uses up the websocket ticket for the channel and returns
+// its user as stored now, rather than as it was when the ticket was issued. The
+// ticket is no good once its session is gone or its user suspended, as the
+// socket it opens would outlive the revocation meant to close it.
+func (app *application) consumeWebsocketTicket(ticketPlaintext string, channelID int64) (*data.User, *data.Ticket, error) {
+	ticket, err := app.models.Ticket.Consume(data.ScopeWebsocket, channelID, ticketPlaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exists, err := app.models.SessionToken.Exists(ticket.UserID, ticket.SessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !exists {
+		return nil, nil, data.ErrRecordNotFound
+	}
+
+	switch _, err := app.models.Suspension.Get(ticket.UserID); {
+	case err == nil:
+		return nil, nil, errSuspended
+	case !errors.Is(err, data.ErrRecordNotFound):
+		return nil, nil, err
+	}
+
+	user, err := app.models.User.GetByID(ticket.UserID)
+	if err != nil {
+		return nil, nil, err
 	}
-	go client.WriteMessage()
+	return user, ticket, nil
 }
@@ -8,7 +8,10 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey    = contextKey("user")
+	sessionContextKey = contextKey("session")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -22,3 +25,15 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetSessionID(r *http.Request, sessionID string) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, sessionID)
+	return r.WithContext(ctx)
+}
+
+// contextGetSessionID returns the ID of the session the request was made with,
+// empty for anonymous requests.
+func (app *application) contextGetSessionID(r *http.Request) string {
+	sessionID, _ := r.Context().Value(sessionContextKey).(string)
+	return sessionID
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"github.com/JunJie-Lai/Chat-App/chat"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
 	"net/http"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -79,3 +83,32 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) bannedResponse(w http.ResponseWriter, r *http.Request, ban *data.Ban) {
+	message := "you are banned from this channel"
+	if ban.IsTimeout() {
+		message = "you are timed out in this channel until " + ban.ExpiresAt.Format(time.RFC3339)
+	}
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) suspendedResponse(w http.ResponseWriter, r *http.Request, suspension *data.Suspension) {
+	message := "your user account has been suspended"
+	if suspension.ExpiresAt != nil {
+		message += " until " + suspension.ExpiresAt.Format(time.RFC3339)
+	}
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) messageChangeErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, data.ErrEditConflict):
+		app.editConflictResponse(w, r)
+	case errors.Is(err, chat.ErrNotPermitted), errors.Is(err, chat.ErrEditWindowPassed), errors.Is(err, chat.ErrBanned):
+		app.errorResponse(w, r, http.StatusForbidden, err.Error())
+	default:
+		app.serverErrorResponse(w, r, err)
+	}
+}
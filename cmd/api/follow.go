@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"net/http"
+)
+
+func (app *application) followChannelHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	follow, err := app.models.Follow.Follow(channelID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.chatServer.Followed(channelID, user.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"follow": follow}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) unfollowChannelHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if err := app.models.Follow.Unfollow(channelID, user.ID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.chatServer.Followed(channelID, user.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "channel unfollowed"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
 	"github.com/JunJie-Lai/Chat-App/internal/validator"
 	"io"
 	"maps"
@@ -11,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type envelope map[string]any
@@ -25,6 +27,36 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+func (app *application) readMessageIDParam(r *http.Request) (int64, error) {
+	param := r.PathValue("message_id")
+
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid message ID parameter")
+	}
+	return id, nil
+}
+
+func (app *application) readUserIDParam(r *http.Request) (int64, error) {
+	param := r.PathValue("user_id")
+
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid user ID parameter")
+	}
+	return id, nil
+}
+
+func (app *application) readReportIDParam(r *http.Request) (int64, error) {
+	param := r.PathValue("report_id")
+
+	id, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid report ID parameter")
+	}
+	return id, nil
+}
+
 func (app *application) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	js, err := json.Marshal(data)
 	if err != nil {
@@ -69,6 +101,31 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := app.readString(qs, key, "")
+	if s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+	return t
+}
+
+// audit records a change to a channel in its audit log. A failure is only
+// logged, as the change itself has already been made.
+func (app *application) audit(r *http.Request, channelID int64, action, targetType string, targetID int64, before, after any) {
+	entry, err := data.NewAuditEntry(channelID, app.contextGetUser(r).ID, action, targetType, targetID, before, after)
+	if err == nil {
+		err = app.models.Audit.Insert(entry)
+	}
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
@@ -5,11 +5,13 @@ import (
 	"database/sql"
 	"github.com/JunJie-Lai/Chat-App/chat"
 	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/mailer"
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 	"log/slog"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -21,6 +23,7 @@ type application struct {
 	logger     *slog.Logger
 	chatServer *chat.Server
 	models     data.Models
+	mailer     mailer.Mailer
 }
 
 func main() {
@@ -41,20 +44,77 @@ func main() {
 		Addr: "localhost:6379",
 	})
 
+	models := data.NewModels(db, redisDB)
+
 	app := &application{
 		logger:     logger,
-		models:     data.NewModels(db, redisDB),
-		chatServer: chat.NewServer(data.NewModels(db, redisDB)),
+		models:     models,
+		chatServer: chat.NewServer(models, redisDB, logger),
+		mailer:     newMailer(logger),
+	}
+
+	if err := bootstrapAdmin(models, os.Getenv("ADMIN_EMAIL")); err != nil {
+		logger.Error("bootstrapping admin", "error", err.Error())
 	}
 
+	archiveCtx, stopArchive := context.WithCancel(context.Background())
+	archived := make(chan struct{})
+	go func() {
+		models.Message.Archive(archiveCtx, logger)
+		close(archived)
+	}()
+
 	go app.chatServer.Run()
 
 	if err := app.serve(); err != nil {
 		logger.Error(err.Error())
 	}
+
+	stopArchive()
+	<-archived
 	os.Exit(1)
 }
 
+// bootstrapAdmin grants the admin role to the user registered with email, so
+// the first admin doesn't have to be made by hand in the database. Nothing is
+// done when email is empty.
+func bootstrapAdmin(models data.Models, email string) error {
+	if email == "" {
+		return nil
+	}
+
+	user, err := models.User.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	if err := models.Permission.AddRoleForUser(user.ID, data.RoleAdmin); err != nil {
+		return err
+	}
+
+	permissions, err := models.Permission.GetAllForUser(user.ID)
+	if err != nil {
+		return err
+	}
+	return models.SessionToken.RefreshPermissions(user.ID, permissions)
+}
+
+// newMailer sends emails through SMTP_HOST when it is set, otherwise they are
+// only logged, or written into MAIL_DIR.
+func newMailer(logger *slog.Logger) mailer.Mailer {
+	sender := os.Getenv("SMTP_SENDER")
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return mailer.NewLog(logger, os.Getenv("MAIL_DIR"), sender)
+	}
+
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		port = 25
+	}
+	return mailer.NewSMTP(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), sender)
+}
+
 func openDB() (*sql.DB, error) {
 	db, err := sql.Open("postgres", os.Getenv("DB"))
 	if err != nil {
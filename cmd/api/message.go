@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"net/http"
+)
+
+func (app *application) listMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filter := data.MessageFilter{
+		BeforeID: int64(app.readInt(qs, "before_id", 0, v)),
+		AfterID:  int64(app.readInt(qs, "after_id", 0, v)),
+		Before:   app.readTime(qs, "before", v),
+		After:    app.readTime(qs, "after", v),
+		Limit:    app.readInt(qs, "limit", 50, v),
+	}
+
+	if data.ValidateMessageFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	channel, err := app.models.Channel.GetExistingChannel(channelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	messages, cursor, err := app.models.Message.GetArchived(channel.ID, filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"messages": messages, "cursor": cursor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) editMessageHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	messageID, err := app.readMessageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Message string `json:"message"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateMessageText(v, input.Message); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	message, err := app.chatServer.EditMessage(channelID, messageID, app.contextGetUser(r), input.Message)
+	if err != nil {
+		app.messageChangeErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": message}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	messageID, err := app.readMessageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if _, err := app.chatServer.DeleteMessage(channelID, messageID, app.contextGetUser(r)); err != nil {
+		app.messageChangeErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "message deleted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	messageID, err := app.readMessageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revisions, err := app.models.Message.GetRevisions(channel.ID, messageID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -104,6 +104,12 @@ func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 
 		token := headerParts[1]
 
+		// Websocket tickets only open a websocket, they never authenticate a request
+		if strings.HasPrefix(token, data.TicketPrefix) {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
 		v := validator.New()
 
 		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
@@ -122,7 +128,23 @@ func (app *application) authenticate(next http.Handler) http.HandlerFunc {
 			return
 		}
 
+		// Suspending a user logs them out, this only catches a session that outlived that
+		switch suspension, err := app.models.Suspension.Get(user.ID); {
+		case err == nil:
+			app.suspendedResponse(w, r, suspension)
+			return
+		case !errors.Is(err, data.ErrRecordNotFound):
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		sessionID := data.SessionID(token)
+		if err := app.models.SessionToken.Touch(user.ID, sessionID); err != nil {
+			app.logger.Error(err.Error())
+		}
+
 		r = app.contextSetUser(r, user)
+		r = app.contextSetSessionID(r, sessionID)
 		next.ServeHTTP(w, r)
 	}
 }
@@ -137,6 +159,30 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 	}
 }
 
+func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if user := app.contextGetUser(r); !user.Activated {
+			app.inactiveAccountResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return app.requireAuthenticatedUser(fn)
+}
+
+// requirePermission lets the request through only if one of the user's roles
+// grants the permission.
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if user := app.contextGetUser(r); !user.Permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return app.requireActivatedUser(fn)
+}
+
 func (app *application) requireNonAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if user := app.contextGetUser(r); !user.IsAnonymous() {
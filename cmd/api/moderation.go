@@ -0,0 +1,483 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"net/http"
+	"time"
+)
+
+// moderatedChannel returns the channel of the request if the user is allowed to
+// moderate it, otherwise it sends the error response and returns false.
+func (app *application) moderatedChannel(w http.ResponseWriter, r *http.Request) (*data.Channel, bool) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	channel, err := app.models.Channel.GetExistingChannel(channelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	moderator, err := app.chatServer.IsModerator(channel.ID, app.contextGetUser(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !moderator {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+	return channel, true
+}
+
+func (app *application) listBansHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	bans, err := app.models.Moderation.GetBans(channel.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"bans": bans}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) banUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID int64  `json:"user_id"`
+		Reason string `json:"reason"`
+		Shadow bool   `json:"shadow"`
+	}
+
+	app.banHandler(w, r, &input, func() *data.Ban {
+		return &data.Ban{UserID: input.UserID, Reason: input.Reason, Shadow: input.Shadow}
+	})
+}
+
+func (app *application) timeoutUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID   int64  `json:"user_id"`
+		Reason   string `json:"reason"`
+		Duration int    `json:"duration"`
+	}
+
+	app.banHandler(w, r, &input, func() *data.Ban {
+		expiresAt := time.Now().Add(time.Duration(input.Duration) * time.Second)
+		return &data.Ban{UserID: input.UserID, Reason: input.Reason, ExpiresAt: &expiresAt}
+	})
+}
+
+// banHandler reads the request into input and applies the ban built from it,
+// shared by bans and timeouts.
+func (app *application) banHandler(w http.ResponseWriter, r *http.Request, input any, newBan func() *data.Ban) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.readJSON(w, r, input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ban := newBan()
+	if !app.applyBan(w, r, channel, ban) {
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"ban": ban}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// applyBan validates and stores the ban of the channel and tells the room about
+// it, otherwise it sends the error response and returns false. Bans from the
+// moderation endpoints and from resolved reports both go through it.
+func (app *application) applyBan(w http.ResponseWriter, r *http.Request, channel *data.Channel, ban *data.Ban) bool {
+	user := app.contextGetUser(r)
+	ban.ChannelID = channel.ID
+	ban.CreatedBy = user.ID
+
+	v := validator.New()
+	v.Check(ban.UserID != channel.UserID, "user_id", "cannot ban the channel owner")
+	v.Check(ban.UserID != user.ID, "user_id", "cannot ban yourself")
+	if data.ValidateBan(v, ban); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	// Only the owner can ban moderators
+	if user.ID != channel.UserID {
+		moderator, err := app.models.Moderation.IsModerator(channel.ID, ban.UserID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return false
+		}
+		if moderator {
+			app.notPermittedResponse(w, r)
+			return false
+		}
+	}
+
+	previous, err := app.models.Moderation.GetBan(channel.ID, ban.UserID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if err := app.models.Moderation.Ban(ban); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "the user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	action := data.AuditBan
+	switch {
+	case ban.IsTimeout():
+		action = data.AuditTimeout
+	case ban.Shadow:
+		action = data.AuditShadowBan
+	}
+	app.audit(r, channel.ID, action, data.TargetUser, ban.UserID, previous, ban)
+
+	if err := app.chatServer.Banned(ban); err != nil {
+		app.logError(r, err)
+	}
+	return true
+}
+
+func (app *application) unbanUserHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	ban, err := app.models.Moderation.GetBan(channel.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.Moderation.Unban(channel.ID, userID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, channel.ID, data.AuditUnban, data.TargetUser, userID, ban, nil)
+
+	if err := app.chatServer.Unbanned(channel.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "ban lifted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listShadowedMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	limit := app.readInt(r.URL.Query(), "limit", 50, v)
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 1000, "limit", "must be a maximum of 1000")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	messages, err := app.models.ShadowMessage.Get(channel.ID, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"messages": messages}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listModeratorsHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	moderators, err := app.models.Moderation.GetModerators(channel.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"moderators": moderators}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addModeratorHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if channel.UserID != user.ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		UserID int64 `json:"user_id"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.UserID > 0, "user_id", "must be provided")
+	v.Check(input.UserID != channel.UserID, "user_id", "the channel owner is already a moderator")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	moderator, err := app.models.Moderation.AddModerator(channel.ID, input.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateModerator):
+			v.AddError("user_id", "the user is already a moderator of this channel")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "the user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, channel.ID, data.AuditModeratorAdd, data.TargetUser, moderator.UserID, nil, moderator)
+
+	if err := app.chatServer.Reload(channel.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"moderator": moderator}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeModeratorHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if channel.UserID != user.ID {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	userID, err := app.readUserIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := app.models.Moderation.RemoveModerator(channel.ID, userID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, channel.ID, data.AuditModeratorRemove, data.TargetUser, userID, envelope{"user_id": userID}, nil)
+
+	if err := app.chatServer.Reload(channel.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "moderator removed"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listHeldMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	held, err := app.models.HeldMessage.GetHeld(channel.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"held_messages": held}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) approveHeldMessageHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	messageID, err := app.readMessageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.chatServer.Approve(channel.ID, messageID, app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "message approved"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) denyHeldMessageHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	messageID, err := app.readMessageIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.chatServer.Deny(channel.ID, messageID, app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "message denied"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateChatModesHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		SlowMode       *int  `json:"slow_mode"`
+		FollowersOnly  *bool `json:"followers_only"`
+		MinAccountAge  *int  `json:"min_account_age"`
+		TrustThreshold *int  `json:"trust_threshold"`
+		EmoteOnly      *bool `json:"emote_only"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	modes := channel.Modes
+	if input.SlowMode != nil {
+		modes.SlowMode = *input.SlowMode
+	}
+	if input.FollowersOnly != nil {
+		modes.FollowersOnly = *input.FollowersOnly
+	}
+	if input.MinAccountAge != nil {
+		modes.MinAccountAge = *input.MinAccountAge
+	}
+	if input.TrustThreshold != nil {
+		modes.TrustThreshold = *input.TrustThreshold
+	}
+	if input.EmoteOnly != nil {
+		modes.EmoteOnly = *input.EmoteOnly
+	}
+
+	v := validator.New()
+	if data.ValidateChatModes(v, modes); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.Channel.UpdateModes(channel.ID, modes); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, channel.ID, data.AuditModesUpdate, data.TargetChannel, channel.ID, channel.Modes, modes)
+
+	if err := app.chatServer.ModesChanged(channel.ID, channel.Modes, modes); err != nil {
+		app.logError(r, err)
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"modes": modes}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
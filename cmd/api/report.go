@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	channelID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		MessageID int64  `json:"message_id,string"`
+		UserID    int64  `json:"user_id"`
+		Category  string `json:"category"`
+		Comment   string `json:"comment"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	reason := &data.ReportReason{ReporterID: user.ID, Category: input.Category, Comment: input.Comment}
+
+	v := validator.New()
+	v.Check(input.MessageID > 0 || input.UserID > 0, "message_id", "either message_id or user_id must be provided")
+	if data.ValidateReportReason(v, reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	channel, err := app.models.Channel.GetExistingChannel(channelID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	report := &data.Report{ChannelID: channel.ID, TargetUserID: input.UserID}
+
+	// Reports of a message keep a copy of it as it was, in case it is edited or
+	// deleted before a moderator gets to it
+	if input.MessageID > 0 {
+		message, err := app.models.Message.GetByID(channel.ID, input.MessageID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("message_id", "the message does not exist")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+		if message.Deleted {
+			v.AddError("message_id", "the message has been deleted")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		report.TargetUserID = message.UserID
+		report.MessageID = message.ID
+		report.Snapshot = message.Message
+	}
+
+	if v.Check(report.TargetUserID != user.ID, "user_id", "cannot report yourself"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.Report.Insert(report, reason); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReport):
+			v.AddError("user_id", "you have already reported this")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("user_id", "the user does not exist")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusCreated, envelope{"message": "report submitted"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	filter := data.ReportFilter{
+		Status:   app.readString(qs, "status", data.ReportOpen),
+		BeforeID: int64(app.readInt(qs, "before_id", 0, v)),
+		Limit:    app.readInt(qs, "limit", 50, v),
+	}
+
+	if data.ValidateReportFilter(v, filter); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	reports, cursor, err := app.models.Report.GetAll(channel.ID, filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"reports": reports, "cursor": cursor}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	channel, ok := app.moderatedChannel(w, r)
+	if !ok {
+		return
+	}
+
+	reportID, err := app.readReportIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Action   string `json:"action"`
+		Reason   string `json:"reason"`
+		Duration int    `json:"duration"`
+	}
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	report, err := app.models.Report.Get(channel.ID, reportID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if report.Status != data.ReportOpen {
+		app.editConflictResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	v.Check(validator.In(input.Action, data.ResolutionDismiss, data.ResolutionDeleteMessage, data.ResolutionTimeout, data.ResolutionBan, data.ResolutionShadowBan),
+		"action", "must be one of dismiss, delete_message, timeout, ban or shadow_ban")
+	v.Check(input.Action != data.ResolutionDeleteMessage || report.MessageID > 0, "action", "the report is not about a message")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	// The report is claimed before its action is taken, so of two moderators
+	// resolving it at once only one takes action
+	before := *report
+	report.Status = data.ReportResolved
+	if input.Action == data.ResolutionDismiss {
+		report.Status = data.ReportDismissed
+	}
+	report.Resolution = input.Action
+	report.ResolvedBy = user.ID
+
+	if err := app.models.Report.Resolve(report); err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// The actions go through the same code as the moderation endpoints
+	applied := true
+	switch input.Action {
+	case data.ResolutionDeleteMessage:
+		if _, err := app.chatServer.DeleteMessage(channel.ID, report.MessageID, user); err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.messageChangeErrorResponse(w, r, err)
+			applied = false
+		}
+	case data.ResolutionTimeout:
+		expiresAt := time.Now().Add(time.Duration(input.Duration) * time.Second)
+		applied = app.applyBan(w, r, channel, &data.Ban{UserID: report.TargetUserID, Reason: input.Reason, ExpiresAt: &expiresAt})
+	case data.ResolutionBan, data.ResolutionShadowBan:
+		ban := &data.Ban{UserID: report.TargetUserID, Reason: input.Reason, Shadow: input.Action == data.ResolutionShadowBan}
+		applied = app.applyBan(w, r, channel, ban)
+	}
+
+	// The error has been sent already, the report is given back to be resolved again
+	if !applied {
+		if err := app.models.Report.Reopen(report); err != nil {
+			app.logError(r, err)
+		}
+		return
+	}
+
+	app.audit(r, channel.ID, data.AuditReportResolve, data.TargetUser, report.TargetUserID, &before, report)
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"report": report}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
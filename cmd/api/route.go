@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/JunJie-Lai/Chat-App/internal/data"
 	"net/http"
 )
 
@@ -8,7 +9,15 @@ func (app *application) route() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", app.notFoundResponse)
-	path := [6]string{"/v1/user/register", "/v1/user/login", "/v1/user/logout", " /v1/channel", "/v1/channel/{id}", "/{$}"}
+	path := [35]string{"/v1/user", "/v1/user/register", "/v1/user/login", "/v1/user/logout", "/v1/user/activated", "/v1/user/password",
+		"/v1/user/sessions", "/v1/user/sessions/{id}", "/v1/tokens/password-reset", "/v1/channel", "/v1/channel/{id}", "/v1/channel/{id}/messages",
+		"/v1/channel/{id}/messages/{message_id}", "/v1/channel/{id}/messages/{message_id}/revisions", "/v1/channel/{id}/presence",
+		"/v1/channel/{id}/moderation/bans", "/v1/channel/{id}/moderation/bans/{user_id}", "/v1/channel/{id}/moderation/timeouts",
+		"/v1/channel/{id}/moderation/moderators", "/v1/channel/{id}/moderation/moderators/{user_id}", "/v1/channel/{id}/moderation/held",
+		"/v1/channel/{id}/moderation/held/{message_id}", "/v1/channel/{id}/moderation/modes", "/v1/channel/{id}/follow",
+		"/v1/channel/{id}/audit", "/v1/channel/{id}/reports", "/v1/channel/{id}/moderation/reports", "/v1/channel/{id}/moderation/shadowed",
+		"/v1/channel/{id}/moderation/reports/{report_id}", "/v1/admin/users/{user_id}/suspension", "/v1/admin/users/{user_id}/roles",
+		"/v1/admin/channel/{id}", "/v1/admin/channel/{id}/moderation", "/v1/admin/audit", "/{$}"}
 	for _, route := range path {
 		mux.HandleFunc(route, app.methodNotAllowedResponse)
 	}
@@ -16,14 +25,55 @@ func (app *application) route() http.Handler {
 	mux.HandleFunc("POST /v1/user/register", app.requireNonAuthenticatedUser(app.registerUserHandler))
 	mux.HandleFunc("POST /v1/user/login", app.requireNonAuthenticatedUser(app.loginUserHandler))
 	mux.HandleFunc("POST /v1/user/logout", app.requireAuthenticatedUser(app.logoutUserHandler))
+	mux.HandleFunc("PATCH /v1/user", app.requireAuthenticatedUser(app.updateUserHandler))
+	mux.HandleFunc("GET /v1/user/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	mux.HandleFunc("DELETE /v1/user/sessions", app.requireAuthenticatedUser(app.deleteOtherSessionsHandler))
+	mux.HandleFunc("DELETE /v1/user/sessions/{id}", app.requireAuthenticatedUser(app.deleteSessionHandler))
+	mux.HandleFunc("PUT /v1/user/activated", app.activateUserHandler)
+	mux.HandleFunc("PUT /v1/user/password", app.updateUserPasswordHandler)
+	mux.HandleFunc("POST /v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
 	mux.HandleFunc("GET /v1/channel", app.requireAuthenticatedUser(app.getAllChannelsHandler))
 	mux.HandleFunc("POST /v1/channel", app.requireAuthenticatedUser(app.createChannelHandler))
 	mux.HandleFunc("PUT /v1/channel", app.requireAuthenticatedUser(app.editChannelHandler))
-	mux.HandleFunc("DELETE v1/channel", app.requireAuthenticatedUser(app.deleteChannelHandler))
+	mux.HandleFunc("DELETE /v1/channel", app.requireAuthenticatedUser(app.deleteChannelHandler))
 
 	mux.HandleFunc("GET /v1/channel/{id}", app.getChannelHandler)
-	mux.HandleFunc("POST /v1/channel/{id}", app.requireAuthenticatedUser(app.superChatHandler))
+	mux.HandleFunc("POST /v1/channel/{id}", app.requireActivatedUser(app.superChatHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/messages", app.listMessagesHandler)
+	mux.HandleFunc("PATCH /v1/channel/{id}/messages/{message_id}", app.requireAuthenticatedUser(app.editMessageHandler))
+	mux.HandleFunc("DELETE /v1/channel/{id}/messages/{message_id}", app.requireAuthenticatedUser(app.deleteMessageHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/messages/{message_id}/revisions", app.requireAuthenticatedUser(app.listRevisionsHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/presence", app.getPresenceHandler)
+	mux.HandleFunc("PUT /v1/channel/{id}/follow", app.requireAuthenticatedUser(app.followChannelHandler))
+	mux.HandleFunc("DELETE /v1/channel/{id}/follow", app.requireAuthenticatedUser(app.unfollowChannelHandler))
+	mux.HandleFunc("POST /v1/channel/{id}/reports", app.requireAuthenticatedUser(app.createReportHandler))
+
+	mux.HandleFunc("GET /v1/channel/{id}/moderation/bans", app.requireAuthenticatedUser(app.listBansHandler))
+	mux.HandleFunc("POST /v1/channel/{id}/moderation/bans", app.requireAuthenticatedUser(app.banUserHandler))
+	mux.HandleFunc("DELETE /v1/channel/{id}/moderation/bans/{user_id}", app.requireAuthenticatedUser(app.unbanUserHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/moderation/shadowed", app.requireAuthenticatedUser(app.listShadowedMessagesHandler))
+	mux.HandleFunc("POST /v1/channel/{id}/moderation/timeouts", app.requireAuthenticatedUser(app.timeoutUserHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/moderation/moderators", app.requireAuthenticatedUser(app.listModeratorsHandler))
+	mux.HandleFunc("POST /v1/channel/{id}/moderation/moderators", app.requireAuthenticatedUser(app.addModeratorHandler))
+	mux.HandleFunc("DELETE /v1/channel/{id}/moderation/moderators/{user_id}", app.requireAuthenticatedUser(app.removeModeratorHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/moderation/held", app.requireAuthenticatedUser(app.listHeldMessagesHandler))
+	mux.HandleFunc("POST /v1/channel/{id}/moderation/held/{message_id}", app.requireAuthenticatedUser(app.approveHeldMessageHandler))
+	mux.HandleFunc("DELETE /v1/channel/{id}/moderation/held/{message_id}", app.requireAuthenticatedUser(app.denyHeldMessageHandler))
+	mux.HandleFunc("PUT /v1/channel/{id}/moderation/modes", app.requireAuthenticatedUser(app.updateChatModesHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/moderation/reports", app.requireAuthenticatedUser(app.listReportsHandler))
+	mux.HandleFunc("POST /v1/channel/{id}/moderation/reports/{report_id}", app.requireAuthenticatedUser(app.resolveReportHandler))
+	mux.HandleFunc("GET /v1/channel/{id}/audit", app.requireAuthenticatedUser(app.listAuditLogHandler))
+
+	mux.HandleFunc("POST /v1/admin/users/{user_id}/suspension", app.requirePermission(data.PermissionUsersSuspend, app.suspendUserHandler))
+	mux.HandleFunc("DELETE /v1/admin/users/{user_id}/suspension", app.requirePermission(data.PermissionUsersSuspend, app.unsuspendUserHandler))
+	mux.HandleFunc("GET /v1/admin/users/{user_id}/roles", app.requirePermission(data.PermissionUsersRoles, app.getUserRolesHandler))
+	mux.HandleFunc("PUT /v1/admin/users/{user_id}/roles", app.requirePermission(data.PermissionUsersRoles, app.updateUserRolesHandler))
+	mux.HandleFunc("DELETE /v1/admin/channel/{id}", app.requirePermission(data.PermissionChannelsDelete, app.forceDeleteChannelHandler))
+	mux.HandleFunc("GET /v1/admin/channel/{id}/moderation", app.requirePermission(data.PermissionModerationRead, app.getChannelModerationHandler))
+
+	mux.HandleFunc("GET /v1/admin/audit", app.requirePermission(data.PermissionAuditRead, app.listSiteAuditLogHandler))
+
 	mux.HandleFunc("GET /{$}", app.websocketHandler)
 
 	return app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(mux))))
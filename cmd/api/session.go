@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"net/http"
+)
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.SessionToken.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	current := app.contextGetSessionID(r)
+	for _, session := range sessions {
+		session.Current = session.ID == current
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	sessionID := r.PathValue("id")
+
+	if err := app.models.SessionToken.DeleteSession(user.ID, sessionID); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.chatServer.Revoke(sessionID); err != nil {
+		app.logger.Error(err.Error())
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "session has been logged out"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteOtherSessionsHandler logs the user out everywhere but the session the
+// request was made with.
+func (app *application) deleteOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessionIDs, err := app.models.SessionToken.DeleteAllForUser(user.ID, app.contextGetSessionID(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.chatServer.Revoke(sessionIDs...); err != nil {
+		app.logger.Error(err.Error())
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "all other sessions have been logged out", "sessions": len(sessionIDs)}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"errors"
+	"github.com/JunJie-Lai/Chat-App/chat"
+	"github.com/JunJie-Lai/Chat-App/internal/automod"
 	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
 	"net/http"
 	"time"
 )
@@ -33,14 +36,86 @@ func (app *application) superChatHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	app.chatServer.Broadcast <- &data.Message{
-		Username:  app.contextGetUser(r).Name,
+	v := validator.New()
+	if data.ValidateMessageText(v, input.Message); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	ban, err := app.models.Moderation.GetBan(channel.ID, user.ID)
+	switch {
+	case err == nil && !ban.Shadow:
+		app.bannedResponse(w, r, ban)
+		return
+	case err != nil && !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	message := &data.Message{
+		UserID:    user.ID,
+		Username:  user.Name,
 		Message:   []byte(input.Message),
 		Timestamp: time.Now(),
 		RoomID:    channel.ID,
 		SuperChat: true,
 	}
 
+	var holdReason string
+	if channel.Automod != nil {
+		filter, err := automod.New(*channel.Automod)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		result := filter.Check(input.Message)
+		switch result.Action {
+		case data.AutomodBlock:
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "your message was blocked: "+result.Reason)
+			return
+		case data.AutomodReplace:
+			message.Message = []byte(result.Text)
+		case data.AutomodHold:
+			holdReason = result.Reason
+		}
+	}
+
+	// Messages from accounts the channel doesn't trust yet wait for a moderator
+	if holdReason == "" && !chat.Trusted(user, channel.Modes) {
+		moderator, err := app.chatServer.IsModerator(channel.ID, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if !moderator {
+			holdReason = "account is newer than the channel's trust threshold"
+		}
+	}
+
+	if holdReason != "" {
+		if err := app.chatServer.Hold(message, holdReason); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if err := app.writeJSON(w, http.StatusAccepted, envelope{"message": "message held for review"}, nil); err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Shadow banned users see their message go through, nobody else does
+	if ban != nil && ban.Shadow {
+		if err := app.chatServer.Shadow(message); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	} else {
+		app.chatServer.Broadcast <- message
+	}
+
 	if err := app.writeJSON(w, http.StatusAccepted, envelope{"message": "message sent"}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
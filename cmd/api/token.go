@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Whether the email belongs to anyone is only found out in the background,
+	// so the response gives nothing away
+	app.background(func() {
+		user, err := app.models.User.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.Error(err.Error())
+			}
+			return
+		}
+
+		token, err := app.models.Token.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		mailData := map[string]any{
+			"passwordResetToken": token.Plaintext,
+			"userName":           user.Name,
+		}
+		if err := app.mailer.Send(user.Email, "token_password_reset.tmpl", mailData); err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "if an account with that email address exists, an email will be sent to it with instructions to reset the password"}
+	if err := app.writeJSON(w, http.StatusAccepted, env, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/JunJie-Lai/Chat-App/internal/data"
 	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"github.com/tomasen/realip"
 	"net/http"
 	"strings"
 	"time"
@@ -48,13 +49,85 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	token, err := app.models.SessionToken.New(user, 7*24*time.Hour)
+	if err := app.models.Permission.SetRolesForUser(user.ID, []string{data.RoleUser}); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.models.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if err := app.writeJSON(w, http.StatusAccepted, envelope{"user": user, "token": token}, nil); err != nil {
+	app.background(func() {
+		mailData := map[string]any{
+			"activationToken": token.Plaintext,
+			"userID":          user.ID,
+			"userName":        user.Name,
+		}
+		if err := app.mailer.Send(user.Email, "user_welcome.tmpl", mailData); err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	if err := app.writeJSON(w, http.StatusAccepted, envelope{"user": user}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := app.models.Token.Consume(data.ScopeActivation, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.User.Activate(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired activation token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Sessions the user logged in before activating pick up the change
+	if err := app.models.SessionToken.Refresh(user); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.models.Token.DeleteAllForUser(data.ScopeActivation, user.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil); err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
@@ -102,7 +175,24 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	token, err := app.models.SessionToken.New(user, 7*24*time.Hour)
+	suspension, err := app.models.Suspension.Get(user.ID)
+	switch {
+	case err == nil:
+		app.suspendedResponse(w, r, suspension)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The permissions are cached with the user for as long as the session lasts
+	if user.Permissions, err = app.models.Permission.GetAllForUser(user.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	session := &data.Session{UserAgent: r.UserAgent(), IP: realip.FromRequest(r)}
+	token, err := app.models.SessionToken.NewSession(user, 7*24*time.Hour, session)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -127,6 +217,192 @@ func (app *application) logoutUserHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if err := app.chatServer.Revoke(data.SessionID(token)); err != nil {
+		app.logger.Error(err.Error())
+	}
+
 	if err := app.writeJSON(w, http.StatusNoContent, envelope{"message": "user has logged out"}, nil); err != nil {
 	}
 }
+
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID, err := app.models.Token.Consume(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := &data.User{ID: userID}
+	if err := user.Password.Set(input.Password); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.models.User.UpdatePassword(user); err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Whoever knew the old password is logged out along with everyone else
+	sessionIDs, err := app.models.SessionToken.DeleteAllForUser(user.ID, "")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err := app.chatServer.Revoke(sessionIDs...); err != nil {
+		app.logger.Error(err.Error())
+	}
+
+	if err := app.models.Token.DeleteAllForUser(data.ScopePasswordReset, user.ID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Username        *string `json:"user_name"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+	}
+
+	if err := app.readJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// The cached user may be out of date, edits are made to the stored one
+	user, err := app.models.User.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	// Changing the email address or password takes the current password
+	emailChanged := input.Email != nil && *input.Email != user.Email
+	if emailChanged || input.Password != nil {
+		match, err := user.Password.Matches(input.CurrentPassword)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if v.Check(match, "current_password", "must match your current password"); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	if input.Username != nil {
+		user.Name = *input.Username
+	}
+	if emailChanged {
+		// The new address has to be verified before the user can chat again
+		user.Email = *input.Email
+		user.Activated = false
+	}
+	if input.Password != nil {
+		if err := user.Password.Set(*input.Password); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.models.User.Update(user); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if err := app.models.SessionToken.Refresh(user); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Tokens sent to the old address or for the old password are no good anymore
+	if emailChanged || input.Password != nil {
+		for _, scope := range []string{data.ScopeActivation, data.ScopePasswordReset} {
+			if err := app.models.Token.DeleteAllForUser(scope, user.ID); err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+	}
+
+	if emailChanged {
+		token, err := app.models.Token.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			mailData := map[string]any{
+				"activationToken": token.Plaintext,
+				"userName":        user.Name,
+			}
+			if err := app.mailer.Send(user.Email, "user_email_changed.tmpl", mailData); err != nil {
+				app.logger.Error(err.Error())
+			}
+		})
+	}
+
+	if err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil); err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -10,6 +10,7 @@ require (
 	github.com/redis/go-redis/v9 v9.7.3
 	github.com/tomasen/realip v0.0.0-20180522021738-f0c99a92ddce
 	golang.org/x/crypto v0.37.0
+	golang.org/x/text v0.24.0
 	golang.org/x/time v0.11.0
 )
 
@@ -0,0 +1,213 @@
+package automod
+
+import (
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	ReasonBlockedTerm = "blocked term"
+	ReasonPattern     = "blocked pattern"
+	ReasonLink        = "link not allowed"
+)
+
+// leetspeak maps the usual stand-ins for letters back to the letters, so that
+// blocked terms can't be dodged by writing them with digits or symbols.
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'8': 'b',
+	'9': 'g',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+	'+': 't',
+}
+
+var linkRX = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]+|\b[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|net|org|io|gg|tv|co|me|ly|app|dev|xyz|info|biz|link|live)\b(?:/[^\s]*)?`)
+
+// Result is what a filter decided about a message. Action is empty when the
+// message broke none of the rules.
+type Result struct {
+	Action string
+	Reason string
+	Text   string
+}
+
+// Filter checks messages against the automod settings of a channel. It is
+// compiled once per settings change and is safe for concurrent use.
+type Filter struct {
+	action   string
+	terms    *regexp.Regexp
+	patterns []*regexp.Regexp
+	links    string
+	domains  []string
+}
+
+func New(settings data.AutomodSettings) (*Filter, error) {
+	filter := &Filter{action: settings.Action, links: settings.Links}
+
+	if len(settings.BlockedTerms) > 0 {
+		terms := make([]string, 0, len(settings.BlockedTerms))
+		for _, term := range settings.BlockedTerms {
+			if term, _ := normalize(term, true); term != "" {
+				terms = append(terms, regexp.QuoteMeta(term))
+			}
+		}
+		// Longer terms first so the longest term wins where terms overlap
+		sort.Slice(terms, func(i, j int) bool {
+			return len(terms[i]) > len(terms[j])
+		})
+		if len(terms) > 0 {
+			rx, err := regexp.Compile(strings.Join(terms, "|"))
+			if err != nil {
+				return nil, err
+			}
+			filter.terms = rx
+		}
+	}
+
+	for _, pattern := range settings.Patterns {
+		rx, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, err
+		}
+		filter.patterns = append(filter.patterns, rx)
+	}
+
+	for _, domain := range settings.AllowedDomains {
+		filter.domains = append(filter.domains, strings.TrimPrefix(strings.ToLower(domain), "www."))
+	}
+	return filter, nil
+}
+
+// Check runs the message through the filter. Blocked terms are matched on the
+// normalized text, patterns and links on the text as written.
+func (f *Filter) Check(text string) Result {
+	runes := []rune(text)
+	var (
+		matches [][2]int
+		reason  string
+	)
+	match := func(start, end int, why string) {
+		matches = append(matches, [2]int{start, end})
+		if reason == "" {
+			reason = why
+		}
+	}
+
+	if f.terms != nil {
+		// Symbols are only sometimes leetspeak, so look at the text both ways
+		for _, leet := range []bool{false, true} {
+			normalized, origin := normalize(text, leet)
+			for _, loc := range f.terms.FindAllStringIndex(normalized, -1) {
+				if insideWord(normalized, loc[0], loc[1]) {
+					continue
+				}
+				match(origin[loc[0]], origin[loc[1]-1]+1, ReasonBlockedTerm)
+			}
+		}
+	}
+
+	for _, rx := range f.patterns {
+		for _, loc := range rx.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			match(utf8.RuneCountInString(text[:loc[0]]), utf8.RuneCountInString(text[:loc[1]]), ReasonPattern)
+		}
+	}
+
+	if f.links != data.LinksAllow {
+		for _, loc := range linkRX.FindAllStringIndex(text, -1) {
+			if f.links == data.LinksAllowlist && f.allowed(text[loc[0]:loc[1]]) {
+				continue
+			}
+			match(utf8.RuneCountInString(text[:loc[0]]), utf8.RuneCountInString(text[:loc[1]]), ReasonLink)
+		}
+	}
+
+	if len(matches) == 0 {
+		return Result{Text: text}
+	}
+	if f.action != data.AutomodReplace {
+		return Result{Action: f.action, Reason: reason, Text: text}
+	}
+
+	for _, m := range matches {
+		for i := m[0]; i < m[1]; i++ {
+			if !unicode.IsSpace(runes[i]) {
+				runes[i] = '*'
+			}
+		}
+	}
+	return Result{Action: f.action, Reason: reason, Text: string(runes)}
+}
+
+func (f *Filter) allowed(link string) bool {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	return slices.ContainsFunc(f.domains, func(domain string) bool {
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	})
+}
+
+// normalize folds the case of the text, strips accents and compatibility forms
+// and, if leet is set, undoes leetspeak. For every byte of the result, origin
+// holds the index of the rune of text it came from.
+func normalize(text string, leet bool) (string, []int) {
+	caser := cases.Fold()
+
+	var (
+		b      strings.Builder
+		origin []int
+	)
+	for i, r := range []rune(text) {
+		if l, ok := leetspeak[r]; ok && leet {
+			r = l
+		}
+		for _, c := range norm.NFKD.String(string(r)) {
+			if unicode.Is(unicode.Mn, c) {
+				continue
+			}
+			folded := caser.String(string(c))
+			b.WriteString(folded)
+			for range len(folded) {
+				origin = append(origin, i)
+			}
+		}
+	}
+	return b.String(), origin
+}
+
+// insideWord reports whether the match from start to end is part of a longer
+// word, so terms don't match inside innocent words.
+func insideWord(s string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(s[:start])
+	after, _ := utf8.DecodeRuneInString(s[end:])
+	return isWord(before) || isWord(after)
+}
+
+func isWord(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsNumber(r))
+}
@@ -0,0 +1,130 @@
+package automod
+
+import (
+	"github.com/JunJie-Lai/Chat-App/internal/data"
+	"testing"
+)
+
+func TestCheckBlockedTerms(t *testing.T) {
+	filter, err := New(data.AutomodSettings{Action: data.AutomodReplace, BlockedTerms: []string{"darn", "Strasse"}, Links: data.LinksAllow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		text   string
+		want   string
+		action string
+	}{
+		{"clean", "hello there", "hello there", ""},
+		{"case folding", "DARN it", "**** it", data.AutomodReplace},
+		{"accents", "dárn it", "**** it", data.AutomodReplace},
+		{"compatibility forms", "ｄａｒｎ it", "**** it", data.AutomodReplace},
+		{"leetspeak digits", "d4rn it", "**** it", data.AutomodReplace},
+		{"leetspeak symbols", "d@rn it", "**** it", data.AutomodReplace},
+		{"fold expanding a rune", "the straße", "the ******", data.AutomodReplace},
+		{"inside a word", "darned socks", "darned socks", ""},
+		{"multi-byte around the term", "héllo 😀darn😀 ünd", "héllo 😀****😀 ünd", data.AutomodReplace},
+		{"every occurrence", "darn, DARN", "****, ****", data.AutomodReplace},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := filter.Check(tt.text)
+			if result.Action != tt.action {
+				t.Errorf("got action %q, want %q", result.Action, tt.action)
+			}
+			if result.Text != tt.want {
+				t.Errorf("got %q, want %q", result.Text, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPatternsReplaceByRune(t *testing.T) {
+	filter, err := New(data.AutomodSettings{Action: data.AutomodReplace, Patterns: []string{`b+a+d`}, Links: data.LinksAllow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := filter.Check("ça va BAAD ça")
+	if want := "ça va **** ça"; result.Text != want {
+		t.Errorf("got %q, want %q", result.Text, want)
+	}
+	if result.Reason != ReasonPattern {
+		t.Errorf("got reason %q, want %q", result.Reason, ReasonPattern)
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		links   string
+		domains []string
+		text    string
+		blocked bool
+	}{
+		{"allowed", data.LinksAllow, nil, "see example.com", false},
+		{"blocked", data.LinksBlock, nil, "see example.com", true},
+		{"blocked with scheme", data.LinksBlock, nil, "see https://example.com/x", true},
+		{"allowlisted", data.LinksAllowlist, []string{"example.com"}, "see www.example.com/page", false},
+		{"allowlisted subdomain", data.LinksAllowlist, []string{"example.com"}, "see clips.example.com", false},
+		{"lookalike domain", data.LinksAllowlist, []string{"example.com"}, "see badexample.com", true},
+		{"not allowlisted", data.LinksAllowlist, []string{"example.com"}, "see evil.net", true},
+		{"no link", data.LinksBlock, nil, "see you later", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := New(data.AutomodSettings{Action: data.AutomodBlock, Links: tt.links, AllowedDomains: tt.domains})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := filter.Check(tt.text)
+			if blocked := result.Action == data.AutomodBlock; blocked != tt.blocked {
+				t.Errorf("got blocked %t, want %t", blocked, tt.blocked)
+			}
+			if tt.blocked && result.Reason != ReasonLink {
+				t.Errorf("got reason %q, want %q", result.Reason, ReasonLink)
+			}
+		})
+	}
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	normalized, origin := normalize("Ünd 5ß", true)
+	if want := "und sss"; normalized != want {
+		t.Fatalf("got %q, want %q", normalized, want)
+	}
+
+	want := []int{0, 1, 2, 3, 4, 5, 5}
+	if len(origin) != len(want) {
+		t.Fatalf("got origin %v, want %v", origin, want)
+	}
+	for i := range want {
+		if origin[i] != want[i] {
+			t.Fatalf("got origin %v, want %v", origin, want)
+		}
+	}
+}
+
+func TestInsideWord(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end int
+		want       bool
+	}{
+		{"darn", 0, 4, false},
+		{"oh darn!", 3, 7, false},
+		{"darned", 0, 4, true},
+		{"undarn", 2, 6, true},
+		{"darn2", 0, 4, true},
+		{"édarn", 2, 6, true},
+		{"😀darn", 4, 8, false},
+	}
+	for _, tt := range tests {
+		if got := insideWord(tt.s, tt.start, tt.end); got != tt.want {
+			t.Errorf("insideWord(%q, %d, %d) = %t, want %t", tt.s, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
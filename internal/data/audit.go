@@ -0,0 +1,183 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"time"
+)
+
+const (
+	AuditChannelCreate   = "channel.create"
+	AuditChannelUpdate   = "channel.update"
+	AuditChannelDelete   = "channel.delete"
+	AuditModesUpdate     = "channel.modes"
+	AuditBan             = "moderation.ban"
+	AuditTimeout         = "moderation.timeout"
+	AuditShadowBan       = "moderation.shadow_ban"
+	AuditUnban           = "moderation.unban"
+	AuditModeratorAdd    = "moderation.moderator_add"
+	AuditModeratorRemove = "moderation.moderator_remove"
+	AuditMessageDelete   = "moderation.message_delete"
+	AuditHeldApprove     = "moderation.held_approve"
+	AuditHeldDeny        = "moderation.held_deny"
+	AuditReportResolve   = "moderation.report_resolve"
+	AuditUserSuspend     = "admin.user_suspend"
+	AuditUserUnsuspend   = "admin.user_unsuspend"
+	AuditUserRoles       = "admin.user_roles"
+)
+
+// AuditSite is the channel ID of the entries for site-wide admin actions, which
+// belong to no channel.
+const AuditSite int64 = 0
+
+var AuditActions = []string{
+	AuditChannelCreate, AuditChannelUpdate, AuditChannelDelete, AuditModesUpdate, AuditBan, AuditTimeout, AuditShadowBan,
+	AuditUnban, AuditModeratorAdd, AuditModeratorRemove, AuditMessageDelete, AuditHeldApprove, AuditHeldDeny, AuditReportResolve,
+	AuditUserSuspend, AuditUserUnsuspend, AuditUserRoles,
+}
+
+const (
+	TargetChannel = "channel"
+	TargetUser    = "user"
+	TargetMessage = "message"
+)
+
+type AuditInterface interface {
+	Insert(*AuditEntry) error
+	GetAll(int64, AuditFilter) ([]*AuditEntry, AuditCursor, error)
+}
+
+// AuditEntry records who changed what in a channel. Before and After hold the
+// changed record as it was and as it became, either is empty when the record
+// was created or removed.
+type AuditEntry struct {
+	ID         int64           `json:"audit_id"`
+	ChannelID  int64           `json:"channel_id"`
+	ActorID    int64           `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int64           `json:"target_id,string"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type AuditFilter struct {
+	BeforeID int64
+	Action   string
+	ActorID  int64
+	TargetID int64
+	Before   time.Time
+	After    time.Time
+	Limit    int
+}
+
+type AuditCursor struct {
+	Before  int64 `json:"before_id,omitempty"`
+	HasMore bool  `json:"has_more"`
+}
+
+type AuditModel struct {
+	db *sql.DB
+}
+
+// NewAuditEntry builds an entry with before and after encoded as JSON, nil
+// values are left out.
+func NewAuditEntry(channelID, actorID int64, action, targetType string, targetID int64, before, after any) (*AuditEntry, error) {
+	entry := &AuditEntry{ChannelID: channelID, ActorID: actorID, Action: action, TargetType: targetType, TargetID: targetID}
+
+	var err error
+	if before != nil {
+		if entry.Before, err = json.Marshal(before); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if entry.After, err = json.Marshal(after); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (m AuditModel) Insert(entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.db.QueryRowContext(ctx, `
+		INSERT INTO audit_log (channel_id, actor_id, action, target_type, target_id, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`,
+		entry.ChannelID, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, nullJSON(entry.Before), nullJSON(entry.After)).
+		Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// GetAll returns a page of the channel's audit log, newest first.
+func (m AuditModel) GetAll(channelID int64, filter AuditFilter) ([]*AuditEntry, AuditCursor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, channel_id, actor_id, action, target_type, target_id, before, after, created_at FROM audit_log
+		WHERE channel_id = $1
+		AND ($2::bigint = 0 OR id < $2::bigint)
+		AND ($3 = '' OR action = $3)
+		AND ($4::bigint = 0 OR actor_id = $4::bigint)
+		AND ($5::bigint = 0 OR target_id = $5::bigint)
+		AND ($6::timestamptz IS NULL OR created_at < $6)
+		AND ($7::timestamptz IS NULL OR created_at > $7)
+		ORDER BY id DESC LIMIT $8`,
+		channelID, filter.BeforeID, filter.Action, filter.ActorID, filter.TargetID, nullTime(filter.Before), nullTime(filter.After), filter.Limit+1)
+	if err != nil {
+		return nil, AuditCursor{}, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var (
+			entry         AuditEntry
+			before, after []byte
+		)
+		if err := rows.Scan(&entry.ID, &entry.ChannelID, &entry.ActorID, &entry.Action, &entry.TargetType, &entry.TargetID, &before, &after, &entry.CreatedAt); err != nil {
+			return nil, AuditCursor{}, err
+		}
+		entry.Before, entry.After = before, after
+		entries = append(entries, &entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, AuditCursor{}, err
+	}
+
+	var cursor AuditCursor
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+		cursor.HasMore = true
+	}
+	if len(entries) > 0 {
+		cursor.Before = entries[len(entries)-1].ID
+	}
+	return entries, cursor, nil
+}
+
+func nullJSON(js json.RawMessage) any {
+	if js == nil {
+		return nil
+	}
+	return []byte(js)
+}
+
+func ValidateAuditFilter(v *validator.Validator, filter AuditFilter) {
+	v.Check(filter.BeforeID >= 0, "before_id", "must be a positive integer")
+	v.Check(filter.ActorID >= 0, "actor_id", "must be a positive integer")
+	v.Check(filter.TargetID >= 0, "target_id", "must be a positive integer")
+	v.Check(filter.Action == "" || validator.In(filter.Action, AuditActions...), "action", "must be a known audit action")
+	v.Check(filter.Limit > 0, "limit", "must be greater than zero")
+	v.Check(filter.Limit <= 100, "limit", "must be a maximum of 100")
+}
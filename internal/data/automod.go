@@ -0,0 +1,72 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"regexp"
+)
+
+const (
+	AutomodBlock   = "block"
+	AutomodReplace = "replace"
+	AutomodHold    = "hold"
+)
+
+const (
+	LinksAllow     = "allow"
+	LinksBlock     = "block"
+	LinksAllowlist = "allowlist"
+)
+
+var DefaultAutomodSettings = AutomodSettings{Action: AutomodBlock, Links: LinksAllow}
+
+// AutomodSettings are the rules every message of a channel is checked against
+// before it reaches the room. Action says what happens to a message that breaks
+// any of them.
+type AutomodSettings struct {
+	Action         string   `json:"action"`
+	BlockedTerms   []string `json:"blocked_terms"`
+	Patterns       []string `json:"patterns"`
+	Links          string   `json:"links"`
+	AllowedDomains []string `json:"allowed_domains"`
+}
+
+func (a AutomodSettings) Value() (driver.Value, error) {
+	return json.Marshal(a)
+}
+
+func (a *AutomodSettings) Scan(value any) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("automod settings must be JSON")
+	}
+
+	settings := DefaultAutomodSettings
+	if err := json.Unmarshal(b, &settings); err != nil {
+		return err
+	}
+	*a = settings
+	return nil
+}
+
+func ValidateAutomodSettings(v *validator.Validator, automod AutomodSettings) {
+	v.Check(validator.In(automod.Action, AutomodBlock, AutomodReplace, AutomodHold), "automod.action", "must be block, replace or hold")
+	v.Check(validator.In(automod.Links, LinksAllow, LinksBlock, LinksAllowlist), "automod.links", "must be allow, block or allowlist")
+
+	v.Check(len(automod.BlockedTerms) <= 500, "automod.blocked_terms", "must not contain more than 500 terms")
+	for _, term := range automod.BlockedTerms {
+		v.Check(term != "", "automod.blocked_terms", "must not contain empty terms")
+		v.Check(len(term) <= 100, "automod.blocked_terms", "must not contain terms more than 100 bytes long")
+	}
+
+	v.Check(len(automod.Patterns) <= 50, "automod.patterns", "must not contain more than 50 patterns")
+	for _, pattern := range automod.Patterns {
+		v.Check(len(pattern) <= 200, "automod.patterns", "must not contain patterns more than 200 bytes long")
+		_, err := regexp.Compile(pattern)
+		v.Check(err == nil, "automod.patterns", "must only contain valid regular expressions")
+	}
+
+	v.Check(len(automod.AllowedDomains) <= 100, "automod.allowed_domains", "must not contain more than 100 domains")
+}
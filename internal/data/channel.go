@@ -8,21 +8,35 @@ import (
 	"time"
 )
 
-var ErrDuplicateChannel = errors.New("duplicate channel")
+var (
+	ErrDuplicateChannel    = errors.New("duplicate channel")
+	DefaultHistorySettings = HistorySettings{Limit: 1000, MaxAge: 24 * 60 * 60, Replay: 50}
+)
 
 type ChannelInterface interface {
 	GetAllChannel(int64) ([]*Channel, error)
 	GetChannel(int64, int64) (*Channel, error)
 	CreateChannel(int64, *Channel) error
-	UpdateChannelName(int64, *Channel) error
+	UpdateChannel(int64, *Channel) error
+	UpdateModes(int64, ChatModes) error
 	DeleteChannel(int64, int64) error
 	GetExistingChannel(int64) (*Channel, error)
 }
 
 type Channel struct {
-	ID        int64     `json:"channel_id"`
-	Name      string    `json:"channel_name"`
-	CreatedAt time.Time `json:"created_at,omitempty"`
+	ID        int64            `json:"channel_id"`
+	UserID    int64            `json:"user_id"`
+	Name      string           `json:"channel_name"`
+	History   HistorySettings  `json:"history"`
+	Automod   *AutomodSettings `json:"automod,omitempty"`
+	Modes     ChatModes        `json:"modes"`
+	CreatedAt time.Time        `json:"created_at,omitempty"`
+}
+
+type HistorySettings struct {
+	Limit  int `json:"limit"`
+	MaxAge int `json:"max_age"`
+	Replay int `json:"replay"`
 }
 
 type ChannelModel struct {
@@ -33,7 +47,7 @@ func (m *ChannelModel) GetAllChannel(userID int64) ([]*Channel, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := m.db.QueryContext(ctx, "SELECT id, name, created_at FROM channel WHERE user_id = $1 ORDER BY created_at DESC", userID)
+	rows, err := m.db.QueryContext(ctx, "SELECT id, name, history_limit, history_max_age, history_replay, automod, modes, created_at FROM channel WHERE user_id = $1 ORDER BY created_at DESC", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -45,8 +59,8 @@ func (m *ChannelModel) GetAllChannel(userID int64) ([]*Channel, error) {
 
 	var channels []*Channel
 	for rows.Next() {
-		var channel Channel
-		if err := rows.Scan(&channel.ID, &channel.Name, &channel.CreatedAt); err != nil {
+		channel := Channel{UserID: userID}
+		if err := rows.Scan(&channel.ID, &channel.Name, &channel.History.Limit, &channel.History.MaxAge, &channel.History.Replay, &channel.Automod, &channel.Modes, &channel.CreatedAt); err != nil {
 			return nil, err
 		}
 		channels = append(channels, &channel)
@@ -63,13 +77,13 @@ func (m *ChannelModel) GetChannel(userID, channelID int64) (*Channel, error) {
 		return nil, ErrRecordNotFound
 	}
 
-	var channel Channel
+	channel := Channel{UserID: userID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := m.db.QueryRowContext(ctx, "SELECT id, name, created_at FROM channel WHERE user_id = $1 AND id = $2", userID, channelID).
-		Scan(&channel.ID, &channel.Name, &channel.CreatedAt); err != nil {
+	if err := m.db.QueryRowContext(ctx, "SELECT id, name, history_limit, history_max_age, history_replay, automod, modes, created_at FROM channel WHERE user_id = $1 AND id = $2", userID, channelID).
+		Scan(&channel.ID, &channel.Name, &channel.History.Limit, &channel.History.MaxAge, &channel.History.Replay, &channel.Automod, &channel.Modes, &channel.CreatedAt); err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
@@ -85,8 +99,11 @@ func (m *ChannelModel) CreateChannel(userID int64, channel *Channel) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := m.db.QueryRowContext(ctx, "INSERT INTO channel (user_id, name) VALUES ($1, $2) RETURNING id, name, created_at", userID, channel.Name).
-		Scan(&channel.ID, &channel.Name, &channel.CreatedAt); err != nil {
+	channel.UserID = userID
+
+	if err := m.db.QueryRowContext(ctx, "INSERT INTO channel (user_id, name, history_limit, history_max_age, history_replay, automod) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, name, history_limit, history_max_age, history_replay, automod, modes, created_at",
+		userID, channel.Name, channel.History.Limit, channel.History.MaxAge, channel.History.Replay, channel.Automod).
+		Scan(&channel.ID, &channel.Name, &channel.History.Limit, &channel.History.MaxAge, &channel.History.Replay, &channel.Automod, &channel.Modes, &channel.CreatedAt); err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "channel_user_id_name_key"`:
 			return ErrDuplicateChannel
@@ -98,7 +115,7 @@ func (m *ChannelModel) CreateChannel(userID int64, channel *Channel) error {
 	return nil
 }
 
-func (m *ChannelModel) UpdateChannelName(userID int64, channel *Channel) error {
+func (m *ChannelModel) UpdateChannel(userID int64, channel *Channel) error {
 	if channel.ID < 1 {
 		return ErrRecordNotFound
 	}
@@ -106,7 +123,9 @@ func (m *ChannelModel) UpdateChannelName(userID int64, channel *Channel) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	result, err := m.db.ExecContext(ctx, "UPDATE channel SET name = $1 WHERE id = $2 AND user_id = $3", channel.Name, channel.ID, userID)
+	result, err := m.db.ExecContext(ctx,
+		"UPDATE channel SET name = $1, history_limit = $2, history_max_age = $3, history_replay = $4, automod = $5 WHERE id = $6 AND user_id = $7",
+		channel.Name, channel.History.Limit, channel.History.MaxAge, channel.History.Replay, channel.Automod, channel.ID, userID)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "channel_user_id_name_key"`:
@@ -128,6 +147,29 @@ func (m *ChannelModel) UpdateChannelName(userID int64, channel *Channel) error {
 	return nil
 }
 
+// UpdateModes changes only the chat modes of the channel, which moderators can
+// do as well as the owner.
+func (m *ChannelModel) UpdateModes(channelID int64, modes ChatModes) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "UPDATE channel SET modes = $1 WHERE id = $2", modes, channelID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
 func (m *ChannelModel) DeleteChannel(userID, channelID int64) error {
 	if channelID < 1 {
 		return ErrRecordNotFound
@@ -161,8 +203,8 @@ func (m *ChannelModel) GetExistingChannel(channelID int64) (*Channel, error) {
 	defer cancel()
 
 	var channel Channel
-	if err := m.db.QueryRowContext(ctx, "SELECT id, name FROM channel WHERE id = $1", channelID).
-		Scan(&channel.ID, &channel.Name); err != nil {
+	if err := m.db.QueryRowContext(ctx, "SELECT id, user_id, name, history_limit, history_max_age, history_replay, automod, modes FROM channel WHERE id = $1", channelID).
+		Scan(&channel.ID, &channel.UserID, &channel.Name, &channel.History.Limit, &channel.History.MaxAge, &channel.History.Replay, &channel.Automod, &channel.Modes); err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
@@ -176,4 +218,20 @@ func (m *ChannelModel) GetExistingChannel(channelID int64) (*Channel, error) {
 func ValidateChannel(v *validator.Validator, channel *Channel) {
 	v.Check(channel.Name != "", "channel_name", "must be provided")
 	v.Check(len(channel.Name) <= 32, "channel_name", "must not be more than 32 characters")
+
+	ValidateHistorySettings(v, channel.History)
+	if channel.Automod != nil {
+		ValidateAutomodSettings(v, *channel.Automod)
+	}
+	ValidateChatModes(v, channel.Modes)
+}
+
+func ValidateHistorySettings(v *validator.Validator, history HistorySettings) {
+	v.Check(history.Limit > 0, "history.limit", "must be greater than zero")
+	v.Check(history.Limit <= 10_000, "history.limit", "must not be more than 10000")
+	v.Check(history.MaxAge >= 60, "history.max_age", "must be at least 60 seconds")
+	v.Check(history.MaxAge <= 7*24*60*60, "history.max_age", "must not be more than 7 days")
+	v.Check(history.Replay >= 0, "history.replay", "must not be negative")
+	v.Check(history.Replay <= 100, "history.replay", "must not be more than 100")
+	v.Check(history.Replay <= history.Limit, "history.replay", "must not be more than history.limit")
 }
@@ -0,0 +1,44 @@
+package data
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+type CooldownInterface interface {
+	Take(int64, int64, time.Duration) (time.Duration, error)
+}
+
+// CooldownModel keeps users from chatting in a room more often than the room's
+// slow mode allows, across every node.
+type CooldownModel struct {
+	redisDB *redis.Client
+}
+
+func cooldownKey(roomID, userID int64) string {
+	return "room:" + strconv.FormatInt(roomID, 10) + ":cooldown:" + strconv.FormatInt(userID, 10)
+}
+
+// Take starts the user's cooldown in the room. If the user is still cooling
+// down from an earlier message, it returns how long is left instead.
+func (m CooldownModel) Take(roomID, userID int64, interval time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key := cooldownKey(roomID, userID)
+	ok, err := m.redisDB.SetNX(ctx, key, 1, interval).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ok {
+		return 0, nil
+	}
+
+	left, err := m.redisDB.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	return max(left, time.Millisecond), nil
+}
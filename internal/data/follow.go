@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type FollowInterface interface {
+	Follow(int64, int64) (*Follow, error)
+	Unfollow(int64, int64) error
+	GetFollow(int64, int64) (*Follow, error)
+}
+
+type Follow struct {
+	ChannelID int64     `json:"channel_id"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type FollowModel struct {
+	db *sql.DB
+}
+
+// Follow makes the user follow the channel, following it again keeps the time
+// the user first followed it.
+func (m FollowModel) Follow(channelID, userID int64) (*Follow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	follow := Follow{ChannelID: channelID, UserID: userID}
+	if err := m.db.QueryRowContext(ctx, `
+		INSERT INTO channel_followers (channel_id, user_id) VALUES ($1, $2)
+		ON CONFLICT (channel_id, user_id) DO UPDATE SET created_at = channel_followers.created_at
+		RETURNING created_at`, channelID, userID).
+		Scan(&follow.CreatedAt); err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &follow, nil
+}
+
+func (m FollowModel) Unfollow(channelID, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM channel_followers WHERE channel_id = $1 AND user_id = $2", channelID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m FollowModel) GetFollow(channelID, userID int64) (*Follow, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	follow := Follow{ChannelID: channelID, UserID: userID}
+	if err := m.db.QueryRowContext(ctx, "SELECT created_at FROM channel_followers WHERE channel_id = $1 AND user_id = $2", channelID, userID).
+		Scan(&follow.CreatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &follow, nil
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// heldTTL is how long a room's held messages wait for review after the last
+// one was held.
+const heldTTL = 24 * time.Hour
+
+type HeldMessageInterface interface {
+	Hold(*HeldMessage) error
+	GetHeld(int64) ([]*HeldMessage, error)
+	Release(int64, int64) (*HeldMessage, error)
+}
+
+// HeldMessage is a message kept from the room until a moderator approves or
+// denies it.
+type HeldMessage struct {
+	Message *Message  `json:"message"`
+	Reason  string    `json:"reason"`
+	HeldAt  time.Time `json:"held_at"`
+}
+
+type HeldMessageModel struct {
+	redisDB *redis.Client
+}
+
+// releaseScript takes a held message out of the queue, so only one moderator
+// gets to approve or deny it.
+var releaseScript = redis.NewScript(`
+local held = redis.call('HGET', KEYS[1], ARGV[1])
+if not held then
+	return false
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+return held
+`)
+
+func heldKey(roomID int64) string {
+	return "room:" + strconv.FormatInt(roomID, 10) + ":held"
+}
+
+func (m HeldMessageModel) Hold(held *HeldMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	msg, err := json.Marshal(held)
+	if err != nil {
+		return err
+	}
+
+	key := heldKey(held.Message.RoomID)
+	pipe := m.redisDB.TxPipeline()
+	pipe.HSet(ctx, key, strconv.FormatInt(held.Message.ID, 10), msg)
+	pipe.Expire(ctx, key, heldTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetHeld returns the messages of the room waiting for review, oldest first.
+func (m HeldMessageModel) GetHeld(roomID int64) ([]*HeldMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	values, err := m.redisDB.HVals(ctx, heldKey(roomID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*HeldMessage, 0, len(values))
+	for _, value := range values {
+		held, err := decodeHeld(roomID, value)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, held)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].Message.ID < messages[j].Message.ID
+	})
+	return messages, nil
+}
+
+// Release removes the held message from the queue and returns it.
+func (m HeldMessageModel) Release(roomID, messageID int64) (*HeldMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	value, err := releaseScript.Run(ctx, m.redisDB, []string{heldKey(roomID)}, strconv.FormatInt(messageID, 10)).Text()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return decodeHeld(roomID, value)
+}
+
+func decodeHeld(roomID int64, value string) (*HeldMessage, error) {
+	var held HeldMessage
+	if err := json.Unmarshal([]byte(value), &held); err != nil {
+		return nil, err
+	}
+	if held.Message == nil {
+		return nil, errors.New("held message without a message")
+	}
+	held.Message.RoomID = roomID
+	return &held, nil
+}
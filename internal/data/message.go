@@ -4,57 +4,589 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
 	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
+const (
+	archiveBatchSize     = 100
+	archiveFlushInterval = time.Second
+	archiveMaxBacklog    = 50 * archiveBatchSize
+	archiveQueueSize     = 10 * archiveBatchSize
+	archiveProbes        = 3
+	archiveRetries       = 3
+)
+
+// MaxMessageLength is the most characters a chat message may have.
+const MaxMessageLength = 500
+
 type MessageInterface interface {
-	Set(*Message) error
-	Get(int64) ([]*Message, error)
+	Set(*Message, HistorySettings) error
+	Get(int64, int) ([]*Message, error)
+	GetAfter(int64, int64, int) ([]*Message, error)
+	Archive(context.Context, *slog.Logger)
+	GetArchived(int64, MessageFilter) ([]*Message, MessageCursor, error)
+	GetByID(int64, int64) (*Message, error)
+	Edit(*Message, int64, []byte) error
+	Delete(*Message, int64) error
+	GetRevisions(int64, int64) ([]*MessageRevision, error)
 }
 
 type Message struct {
-	Username  string    `json:"username"`
+	ID        int64      `json:"message_id,string"`
+	UserID    int64      `json:"user_id"`
+	Username  string     `json:"username"`
+	Message   []byte     `json:"message"`
+	Timestamp time.Time  `json:"timestamp"`
+	SuperChat bool       `json:"super_chat"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+	RoomID    int64      `json:"-"`
+
+	// raw is the message as it is stored in the room's recent history
+	raw string
+}
+
+type MessageRevision struct {
+	ID        int64     `json:"revision_id"`
+	MessageID int64     `json:"message_id,string"`
 	Message   []byte    `json:"message"`
-	Timestamp time.Time `json:"timestamp"`
-	SuperChat bool      `json:"super_chat"`
-	RoomID    int64     `json:"-"`
+	EditedBy  int64     `json:"edited_by"`
+	Deleted   bool      `json:"deleted"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type messageChange struct {
+	messageID int64
+	previous  []byte
+	message   []byte
+	editorID  int64
+	deleted   bool
+	at        time.Time
+}
+
+type MessageFilter struct {
+	BeforeID int64
+	AfterID  int64
+	Before   time.Time
+	After    time.Time
+	Limit    int
+}
+
+type MessageCursor struct {
+	Before  int64 `json:"before_id,string,omitempty"`
+	After   int64 `json:"after_id,string,omitempty"`
+	HasMore bool  `json:"has_more"`
 }
 
 type MessageModel struct {
 	db      *sql.DB
 	redisDB *redis.Client
+	archive chan *Message
+
+	// dropped counts what didn't fit in the archive queue since the archiver
+	// last logged it
+	dropped *atomic.Int64
+}
+
+// replaceScript swaps a message in the room's recent history for its new
+// version, keeping its place. It fails if the message was changed or trimmed
+// in the meantime.
+var replaceScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[1], score, ARGV[2])
+return 1
+`)
+
+func historyKey(roomID int64) string {
+	return "room:" + strconv.FormatInt(roomID, 10) + ":history"
 }
 
-func (m *MessageModel) Set(message *Message) error {
+// Set adds the message to the recent history of the room, trimming it down to
+// the channel's history settings, and queues it for archiving.
+func (m *MessageModel) Set(message *Message, history HistorySettings) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	key := "room:" + strconv.FormatInt(message.RoomID, 10) + ":messages"
 	msg, err := json.Marshal(message)
 	if err != nil {
 		return err
 	}
-	m.redisDB.RPush(ctx, key, msg)
-	m.redisDB.ExpireXX(context.Background(), key, 24*time.Hour)
+
+	key := historyKey(message.RoomID)
+	maxAge := time.Duration(history.MaxAge) * time.Second
+	oldest := time.Now().Add(-maxAge).UnixMilli()
+
+	pipe := m.redisDB.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(message.Timestamp.UnixMilli()), Member: msg})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(oldest, 10))
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-history.Limit-1))
+	pipe.Expire(ctx, key, maxAge)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	m.enqueue(message)
 	return nil
 }
 
-func (m *MessageModel) Get(roomID int64) ([]*Message, error) {
-	key := "room:" + strconv.FormatInt(roomID, 10) + ":messages"
-	result, err := m.redisDB.LRange(context.Background(), key, 0, -1).Result()
-	if err != nil {
-		return nil, err
+// enqueue hands the message to the archiver without waiting. The message is
+// already in the recent history by then, so when Postgres can't keep up it is
+// dropped from the archive rather than holding up the chat.
+func (m *MessageModel) enqueue(message *Message) {
+	select {
+	case m.archive <- message:
+	default:
+		m.dropped.Add(1)
 	}
+}
 
+func decodeHistory(roomID int64, result []string) ([]*Message, error) {
 	var messages []*Message
 	for _, message := range result {
 		var msg Message
 		if err := json.Unmarshal([]byte(message), &msg); err != nil {
 			return nil, err
 		}
+		msg.RoomID = roomID
+		msg.raw = message
 		messages = append(messages, &msg)
 	}
 	return messages, nil
 }
+
+// Get returns up to the last n messages of the room's recent history, oldest first.
+func (m *MessageModel) Get(roomID int64, n int) ([]*Message, error) {
+	if n < 1 {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.redisDB.ZRange(ctx, historyKey(roomID), int64(-n), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeHistory(roomID, result)
+}
+
+// GetAfter returns up to the last n messages of the room's recent history that
+// came after the message with the given ID, oldest first.
+func (m *MessageModel) GetAfter(roomID, messageID int64, n int) ([]*Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.redisDB.ZRange(ctx, historyKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := decodeHistory(roomID, result)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*Message
+	for _, msg := range history {
+		if msg.ID > messageID {
+			messages = append(messages, msg)
+		}
+	}
+
+	if len(messages) > n {
+		messages = messages[len(messages)-n:]
+	}
+	return messages, nil
+}
+
+// Archive writes the messages passed to Set into Postgres in batches until ctx
+// is cancelled, after which the remaining messages are flushed and it returns.
+func (m *MessageModel) Archive(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(archiveFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Message
+	failures := make(map[int64]int)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := m.write(batch); err != nil {
+			logger.Error(err.Error(), "messages", len(batch))
+			batch = m.writeEach(batch, failures, logger)
+			// Keep what is left for the next flush unless Postgres has been down for too long
+			if len(batch) < archiveMaxBacklog {
+				return
+			}
+			logger.Error("dropping unarchived messages", "messages", len(batch))
+		}
+		batch = nil
+		clear(failures)
+	}
+
+	for {
+		select {
+		case message := <-m.archive:
+			batch = append(batch, message)
+			if len(batch)%archiveBatchSize == 0 {
+				flush()
+			}
+		case <-ticker.C:
+			if dropped := m.dropped.Swap(0); dropped > 0 {
+				logger.Error("archive queue full, messages were not archived", "messages", dropped)
+			}
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case message := <-m.archive:
+					batch = append(batch, message)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (m *MessageModel) GetArchived(roomID int64, filter MessageFilter) ([]*Message, MessageCursor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Page forwards only when the client asks for what came after a cursor,
+	// otherwise page backwards from the newest message.
+	order := "DESC"
+	if (filter.AfterID > 0 || !filter.After.IsZero()) && filter.BeforeID == 0 && filter.Before.IsZero() {
+		order = "ASC"
+	}
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT id, user_id, username, message, super_chat, created_at, edited_at, deleted_at IS NOT NULL FROM messages
+		WHERE channel_id = $1
+		AND ($2::bigint = 0 OR id < $2::bigint)
+		AND ($3::bigint = 0 OR id > $3::bigint)
+		AND ($4::timestamptz IS NULL OR created_at < $4)
+		AND ($5::timestamptz IS NULL OR created_at > $5)
+		ORDER BY id `+order+` LIMIT $6`,
+		roomID, filter.BeforeID, filter.AfterID, nullTime(filter.Before), nullTime(filter.After), filter.Limit+1)
+	if err != nil {
+		return nil, MessageCursor{}, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	messages := []*Message{}
+	for rows.Next() {
+		msg := Message{RoomID: roomID}
+		if err := rows.Scan(&msg.ID, &msg.UserID, &msg.Username, &msg.Message, &msg.SuperChat, &msg.Timestamp, &msg.EditedAt, &msg.Deleted); err != nil {
+			return nil, MessageCursor{}, err
+		}
+		messages = append(messages, &msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, MessageCursor{}, err
+	}
+
+	var cursor MessageCursor
+	if len(messages) > filter.Limit {
+		messages = messages[:filter.Limit]
+		cursor.HasMore = true
+	}
+	if order == "DESC" {
+		slices.Reverse(messages)
+	}
+	if len(messages) > 0 {
+		cursor.Before = messages[0].ID
+		cursor.After = messages[len(messages)-1].ID
+	}
+	return messages, cursor, nil
+}
+
+// GetByID returns the message from the room's recent history, or from the
+// archive once it is no longer recent.
+func (m *MessageModel) GetByID(roomID, messageID int64) (*Message, error) {
+	if messageID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.redisDB.ZRange(ctx, historyKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := decodeHistory(roomID, result)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range history {
+		if msg.ID == messageID {
+			return msg, nil
+		}
+	}
+
+	msg := Message{RoomID: roomID}
+	if err := m.db.QueryRowContext(ctx,
+		"SELECT id, user_id, username, message, super_chat, created_at, edited_at, deleted_at IS NOT NULL FROM messages WHERE channel_id = $1 AND id = $2",
+		roomID, messageID).
+		Scan(&msg.ID, &msg.UserID, &msg.Username, &msg.Message, &msg.SuperChat, &msg.Timestamp, &msg.EditedAt, &msg.Deleted); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &msg, nil
+}
+
+// Edit replaces the text of the message, keeping the previous text as a revision.
+func (m *MessageModel) Edit(message *Message, editorID int64, text []byte) error {
+	now := time.Now()
+	previous := message.Message
+
+	message.Message = text
+	message.EditedAt = &now
+	return m.change(message, &messageChange{
+		messageID: message.ID,
+		previous:  previous,
+		message:   text,
+		editorID:  editorID,
+		at:        now,
+	})
+}
+
+// Delete turns the message into a tombstone, keeping its text as a revision.
+func (m *MessageModel) Delete(message *Message, deleterID int64) error {
+	previous := message.Message
+
+	message.Message = []byte{}
+	message.Deleted = true
+	return m.change(message, &messageChange{
+		messageID: message.ID,
+		previous:  previous,
+		message:   message.Message,
+		editorID:  deleterID,
+		deleted:   true,
+		at:        time.Now(),
+	})
+}
+
+// change writes the change straight to Postgres rather than through the archive
+// queue, which may drop it, as history is read from Postgres and a lost delete
+// would bring the message back. The message is archived along with it when it
+// hasn't been yet. The transaction is only committed once the recent history
+// has been replaced, so a conflicting change there leaves Postgres untouched.
+func (m *MessageModel) change(message *Message, change *messageChange) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	original := *message
+	original.Message = change.previous
+	if err := insertMessages(ctx, tx, []*Message{&original}); err != nil {
+		return err
+	}
+	if err := writeChange(ctx, tx, change); err != nil {
+		return err
+	}
+
+	// Messages that are only archived have nothing to replace in the recent history
+	if message.raw != "" {
+		msg, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+
+		replaced, err := replaceScript.Run(ctx, m.redisDB, []string{historyKey(message.RoomID)}, message.raw, msg).Int()
+		if err != nil {
+			return err
+		}
+		if replaced == 0 {
+			return ErrEditConflict
+		}
+		message.raw = string(msg)
+	}
+
+	return tx.Commit()
+}
+
+// GetRevisions returns the earlier versions of the message, oldest first.
+func (m *MessageModel) GetRevisions(roomID, messageID int64) ([]*MessageRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT r.id, r.message_id, r.message, r.edited_by, r.deleted, r.created_at FROM message_revisions r
+		INNER JOIN messages ON messages.id = r.message_id
+		WHERE messages.channel_id = $1 AND r.message_id = $2
+		ORDER BY r.id`,
+		roomID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	revisions := []*MessageRevision{}
+	for rows.Next() {
+		var revision MessageRevision
+		if err := rows.Scan(&revision.ID, &revision.MessageID, &revision.Message, &revision.EditedBy, &revision.Deleted, &revision.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// writeEach archives the messages of a failed batch one at a time and returns
+// the ones that still failed, to be tried again on the next flush. A message
+// that keeps failing while others get through is taken to be bad rather than
+// hit by a passing error, and is dropped after archiveRetries flushes so it
+// doesn't hold up the messages behind it forever. failures counts the flushes
+// each message has failed in. Nothing queued depends on a dropped message, as
+// edits and deletes don't go through the queue.
+func (m *MessageModel) writeEach(batch []*Message, failures map[int64]int, logger *slog.Logger) []*Message {
+	var failed []*Message
+	for i, message := range batch {
+		if err := m.write([]*Message{message}); err != nil {
+			failed = append(failed, message)
+		} else {
+			delete(failures, message.ID)
+		}
+		// Nothing is getting through, so Postgres is down rather than the messages bad
+		if len(failed) == archiveProbes && len(failed) == i+1 {
+			return batch
+		}
+	}
+	if len(failed) == len(batch) {
+		return failed
+	}
+
+	var kept []*Message
+	dropped := 0
+	for _, message := range failed {
+		failures[message.ID]++
+		if failures[message.ID] > archiveRetries {
+			delete(failures, message.ID)
+			dropped++
+			continue
+		}
+		kept = append(kept, message)
+	}
+	if dropped > 0 {
+		logger.Error("dropping messages that could not be archived", "messages", dropped)
+	}
+	return kept
+}
+
+// write archives the batch in one transaction.
+func (m *MessageModel) write(batch []*Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if err := insertMessages(ctx, tx, batch); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeChange keeps the message as it was as a revision and applies the change.
+func writeChange(ctx context.Context, tx *sql.Tx, change *messageChange) error {
+	// The message may be gone along with its channel by now
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO message_revisions (message_id, message, edited_by, deleted, created_at)
+		SELECT $1::bigint, $2::bytea, $3::bigint, $4::boolean, $5::timestamptz
+		WHERE EXISTS (SELECT 1 FROM messages WHERE id = $1::bigint)`,
+		change.messageID, change.previous, change.editorID, change.deleted, change.at); err != nil {
+		return err
+	}
+
+	query := "UPDATE messages SET message = $1, edited_at = $2 WHERE id = $3"
+	if change.deleted {
+		query = "UPDATE messages SET message = $1, deleted_at = $2 WHERE id = $3"
+	}
+	_, err := tx.ExecContext(ctx, query, change.message, change.at, change.messageID)
+	return err
+}
+
+func insertMessages(ctx context.Context, tx *sql.Tx, messages []*Message) error {
+	values := make([]string, 0, len(messages))
+	args := make([]any, 0, 7*len(messages))
+	for i, message := range messages {
+		n := 7 * i
+		values = append(values, "($"+strconv.Itoa(n+1)+"::bigint, $"+strconv.Itoa(n+2)+"::bigint, $"+strconv.Itoa(n+3)+"::bigint, $"+strconv.Itoa(n+4)+
+			"::text, $"+strconv.Itoa(n+5)+"::bytea, $"+strconv.Itoa(n+6)+"::boolean, $"+strconv.Itoa(n+7)+"::timestamptz)")
+		args = append(args, message.ID, message.RoomID, message.UserID, message.Username, message.Message, message.SuperChat, message.Timestamp)
+	}
+
+	// Messages of channels deleted in the meantime are skipped, as are messages
+	// already archived by an earlier attempt or a change made to them, so
+	// neither fails the whole batch nor overwrites the change
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO messages (id, channel_id, user_id, username, message, super_chat, created_at)
+		SELECT v.id, v.channel_id, v.user_id, v.username, v.message, v.super_chat, v.created_at
+		FROM (VALUES `+strings.Join(values, ", ")+`) AS v (id, channel_id, user_id, username, message, super_chat, created_at)
+		WHERE EXISTS (SELECT 1 FROM channel WHERE channel.id = v.channel_id)
+		ON CONFLICT (id) DO NOTHING`,
+		args...)
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func ValidateMessageText(v *validator.Validator, text string) {
+	v.Check(strings.TrimSpace(text) != "", "message", "must be provided")
+	v.Check(utf8.RuneCountInString(text) <= MaxMessageLength, "message", "must not be more than "+strconv.Itoa(MaxMessageLength)+" characters")
+}
+
+func ValidateMessageFilter(v *validator.Validator, filter MessageFilter) {
+	v.Check(filter.BeforeID >= 0, "before_id", "must be a positive integer")
+	v.Check(filter.AfterID >= 0, "after_id", "must be a positive integer")
+	v.Check(filter.Limit > 0, "limit", "must be greater than zero")
+	v.Check(filter.Limit <= 100, "limit", "must be a maximum of 100")
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/redis/go-redis/v9"
+	"sync/atomic"
 )
 
 var (
@@ -12,17 +13,43 @@ var (
 )
 
 type Models struct {
-	User         UserInterface
-	SessionToken SessionTokenInterface
-	Channel      ChannelInterface
-	Message      MessageInterface
+	User          UserInterface
+	Permission    PermissionInterface
+	Suspension    SuspensionInterface
+	SessionToken  SessionTokenInterface
+	Ticket        TicketInterface
+	Token         TokenInterface
+	Channel       ChannelInterface
+	Message       MessageInterface
+	Presence      PresenceInterface
+	Moderation    ModerationInterface
+	HeldMessage   HeldMessageInterface
+	ShadowMessage ShadowMessageInterface
+	Follow        FollowInterface
+	Cooldown      CooldownInterface
+	RateLimit     RateLimitInterface
+	Audit         AuditInterface
+	Report        ReportInterface
 }
 
 func NewModels(db *sql.DB, redisDB *redis.Client) Models {
 	return Models{
-		User:         &UserModel{db, redisDB},
-		SessionToken: &SessionTokenModel{redisDB},
-		Channel:      &ChannelModel{db},
-		Message:      &MessageModel{db, redisDB},
+		User:          &UserModel{db, redisDB},
+		Permission:    &PermissionModel{db},
+		Suspension:    &SuspensionModel{db},
+		SessionToken:  &SessionTokenModel{redisDB},
+		Ticket:        &TicketModel{redisDB},
+		Token:         &TokenModel{redisDB},
+		Channel:       &ChannelModel{db},
+		Message:       &MessageModel{db, redisDB, make(chan *Message, archiveQueueSize), new(atomic.Int64)},
+		Presence:      &PresenceModel{redisDB, newNodeID()},
+		Moderation:    &ModerationModel{db},
+		HeldMessage:   &HeldMessageModel{redisDB},
+		ShadowMessage: &ShadowMessageModel{redisDB},
+		Follow:        &FollowModel{db},
+		Cooldown:      &CooldownModel{redisDB},
+		RateLimit:     &RateLimitModel{redisDB},
+		Audit:         &AuditModel{db},
+		Report:        &ReportModel{db},
 	}
 }
@@ -0,0 +1,254 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"strings"
+	"time"
+)
+
+var ErrDuplicateModerator = errors.New("duplicate moderator")
+
+type ModerationInterface interface {
+	GetModerators(int64) ([]*Moderator, error)
+	IsModerator(int64, int64) (bool, error)
+	AddModerator(int64, int64) (*Moderator, error)
+	RemoveModerator(int64, int64) error
+	GetBans(int64) ([]*Ban, error)
+	GetBan(int64, int64) (*Ban, error)
+	Ban(*Ban) error
+	Unban(int64, int64) error
+}
+
+type Moderator struct {
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"user_name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Ban keeps a user from chatting in a channel. Bans without an expiry are
+// permanent, the others are timeouts. Shadow bans are permanent bans the user
+// isn't told about, their messages only ever reach themselves.
+type Ban struct {
+	ChannelID int64      `json:"channel_id"`
+	UserID    int64      `json:"user_id"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Shadow    bool       `json:"shadow"`
+	CreatedBy int64      `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type ModerationModel struct {
+	db *sql.DB
+}
+
+func (b *Ban) IsTimeout() bool {
+	return b.ExpiresAt != nil
+}
+
+func (b *Ban) Active() bool {
+	return b.ExpiresAt == nil || b.ExpiresAt.After(time.Now())
+}
+
+func isForeignKeyViolation(err error) bool {
+	return strings.HasPrefix(err.Error(), "pq: insert or update on table") && strings.Contains(err.Error(), "violates foreign key constraint")
+}
+
+func (m ModerationModel) GetModerators(channelID int64) ([]*Moderator, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT users.id, users.name, channel_moderators.created_at FROM channel_moderators
+		INNER JOIN users ON users.id = channel_moderators.user_id
+		WHERE channel_moderators.channel_id = $1
+		ORDER BY channel_moderators.created_at`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	moderators := []*Moderator{}
+	for rows.Next() {
+		var moderator Moderator
+		if err := rows.Scan(&moderator.UserID, &moderator.Name, &moderator.CreatedAt); err != nil {
+			return nil, err
+		}
+		moderators = append(moderators, &moderator)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return moderators, nil
+}
+
+func (m ModerationModel) IsModerator(channelID, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	if err := m.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM channel_moderators WHERE channel_id = $1 AND user_id = $2)", channelID, userID).
+		Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (m ModerationModel) AddModerator(channelID, userID int64) (*Moderator, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	moderator := Moderator{UserID: userID}
+	if err := m.db.QueryRowContext(ctx, `
+		WITH moderator AS (
+			INSERT INTO channel_moderators (channel_id, user_id) VALUES ($1, $2) RETURNING user_id, created_at
+		)
+		SELECT users.name, moderator.created_at FROM moderator INNER JOIN users ON users.id = moderator.user_id`,
+		channelID, userID).
+		Scan(&moderator.Name, &moderator.CreatedAt); err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "channel_moderators_pkey"`:
+			return nil, ErrDuplicateModerator
+		case isForeignKeyViolation(err):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &moderator, nil
+}
+
+func (m ModerationModel) RemoveModerator(channelID, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "DELETE FROM channel_moderators WHERE channel_id = $1 AND user_id = $2", channelID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetBans returns the bans and timeouts of the channel that are still in effect.
+func (m ModerationModel) GetBans(channelID int64) ([]*Ban, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT channel_id, user_id, reason, expires_at, shadow, created_by, created_at FROM channel_bans
+		WHERE channel_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at DESC`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	bans := []*Ban{}
+	for rows.Next() {
+		var ban Ban
+		if err := rows.Scan(&ban.ChannelID, &ban.UserID, &ban.Reason, &ban.ExpiresAt, &ban.Shadow, &ban.CreatedBy, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, &ban)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+// GetBan returns the ban or timeout of the user in the channel if it is still in effect.
+func (m ModerationModel) GetBan(channelID, userID int64) (*Ban, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var ban Ban
+	if err := m.db.QueryRowContext(ctx, `
+		SELECT channel_id, user_id, reason, expires_at, shadow, created_by, created_at FROM channel_bans
+		WHERE channel_id = $1 AND user_id = $2 AND (expires_at IS NULL OR expires_at > NOW())`, channelID, userID).
+		Scan(&ban.ChannelID, &ban.UserID, &ban.Reason, &ban.ExpiresAt, &ban.Shadow, &ban.CreatedBy, &ban.CreatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &ban, nil
+}
+
+// Ban bans or times out the user, replacing any earlier ban of the user in the channel.
+func (m ModerationModel) Ban(ban *Ban) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.db.QueryRowContext(ctx, `
+		INSERT INTO channel_bans (channel_id, user_id, reason, expires_at, shadow, created_by) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (channel_id, user_id) DO UPDATE
+		SET reason = EXCLUDED.reason, expires_at = EXCLUDED.expires_at, shadow = EXCLUDED.shadow, created_by = EXCLUDED.created_by, created_at = NOW()
+		RETURNING created_at`,
+		ban.ChannelID, ban.UserID, ban.Reason, ban.ExpiresAt, ban.Shadow, ban.CreatedBy).
+		Scan(&ban.CreatedAt); err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ModerationModel) Unban(channelID, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx,
+		"DELETE FROM channel_bans WHERE channel_id = $1 AND user_id = $2 AND (expires_at IS NULL OR expires_at > NOW())", channelID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func ValidateBan(v *validator.Validator, ban *Ban) {
+	v.Check(ban.UserID > 0, "user_id", "must be provided")
+	v.Check(len(ban.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	v.Check(!ban.Shadow || ban.ExpiresAt == nil, "shadow", "timeouts cannot be shadow bans")
+	if ban.ExpiresAt != nil {
+		v.Check(ban.ExpiresAt.After(time.Now()), "duration", "must be greater than zero")
+		v.Check(ban.ExpiresAt.Before(time.Now().Add(14*24*time.Hour)), "duration", "must not be more than 14 days")
+	}
+}
@@ -0,0 +1,40 @@
+package data
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+)
+
+// ChatModes limit who can chat in a channel and how. SlowMode, MinAccountAge
+// and TrustThreshold are in seconds, zero turns them off. Messages from accounts
+// younger than TrustThreshold are held for a moderator to review.
+type ChatModes struct {
+	SlowMode       int  `json:"slow_mode"`
+	FollowersOnly  bool `json:"followers_only"`
+	MinAccountAge  int  `json:"min_account_age"`
+	TrustThreshold int  `json:"trust_threshold"`
+	EmoteOnly      bool `json:"emote_only"`
+}
+
+func (c ChatModes) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *ChatModes) Scan(value any) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return errors.New("chat modes must be JSON")
+	}
+	return json.Unmarshal(b, c)
+}
+
+func ValidateChatModes(v *validator.Validator, modes ChatModes) {
+	v.Check(modes.SlowMode >= 0, "modes.slow_mode", "must not be negative")
+	v.Check(modes.SlowMode <= 60*60, "modes.slow_mode", "must not be more than 1 hour")
+	v.Check(modes.MinAccountAge >= 0, "modes.min_account_age", "must not be negative")
+	v.Check(modes.MinAccountAge <= 90*24*60*60, "modes.min_account_age", "must not be more than 90 days")
+	v.Check(modes.TrustThreshold >= 0, "modes.trust_threshold", "must not be negative")
+	v.Check(modes.TrustThreshold <= 90*24*60*60, "modes.trust_threshold", "must not be more than 90 days")
+}
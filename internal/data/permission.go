@@ -0,0 +1,193 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleStaff = "staff"
+	RoleUser  = "user"
+)
+
+var Roles = []string{RoleAdmin, RoleStaff, RoleUser}
+
+const (
+	PermissionUsersSuspend   = "users:suspend"
+	PermissionUsersRoles     = "users:roles"
+	PermissionChannelsDelete = "channels:delete"
+	PermissionModerationRead = "channels:moderation:read"
+	PermissionAuditRead      = "audit:read"
+)
+
+type PermissionInterface interface {
+	GetAllForUser(int64) (Permissions, error)
+	GetRolesForUser(int64) ([]string, error)
+	SetRolesForUser(int64, []string) error
+	AddRoleForUser(int64, string) error
+}
+
+// Permissions are the codes of everything a user's roles allow them to do
+// across the whole site, on top of what they can do in their own channels.
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+// Covers reports whether p has every one of the other permissions.
+func (p Permissions) Covers(other Permissions) bool {
+	for _, code := range other {
+		if !p.Include(code) {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary lets the permissions be cached with the user under its session
+// tokens.
+func (p Permissions) MarshalBinary() ([]byte, error) {
+	return []byte(strings.Join(p, ",")), nil
+}
+
+func (p *Permissions) ScanRedis(s string) error {
+	*p = nil
+	if s != "" {
+		*p = strings.Split(s, ",")
+	}
+	return nil
+}
+
+type PermissionModel struct {
+	db *sql.DB
+}
+
+func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT DISTINCT permissions.code FROM permissions
+		INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		WHERE users_roles.user_id = $1
+		ORDER BY permissions.code`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	var permissions Permissions
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (m PermissionModel) GetRolesForUser(userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT roles.name FROM roles
+		INNER JOIN users_roles ON users_roles.role_id = roles.id
+		WHERE users_roles.user_id = $1
+		ORDER BY roles.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetRolesForUser replaces the roles of the user.
+func (m PermissionModel) SetRolesForUser(userID int64, roles []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users_roles WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO users_roles (user_id, role_id) SELECT $1, id FROM roles WHERE name = $2", userID, role); err != nil {
+			switch {
+			case isForeignKeyViolation(err):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// AddRoleForUser gives the user the role on top of the roles they already have.
+func (m PermissionModel) AddRoleForUser(userID int64, role string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, err := m.db.ExecContext(ctx, `
+		INSERT INTO users_roles (user_id, role_id) SELECT $1, id FROM roles WHERE name = $2
+		ON CONFLICT DO NOTHING`, userID, role); err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func ValidateRoles(v *validator.Validator, roles []string) {
+	v.Check(roles != nil, "roles", "must be provided")
+	v.Check(validator.Unique(roles), "roles", "must not contain duplicate values")
+	for _, role := range roles {
+		v.Check(validator.In(role, Roles...), "roles", "must be one of "+strings.Join(Roles, ", "))
+	}
+}
@@ -0,0 +1,256 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// presenceTTL is how long a node's connections count towards a room without a
+// heartbeat, after which the node is taken to be gone. Nodes beat a few times
+// within it.
+const presenceTTL = 45 * time.Second
+
+type PresenceInterface interface {
+	Join(int64, *User) (bool, error)
+	Leave(int64, *User, time.Duration) (bool, error)
+	Left(int64, *User) (bool, error)
+	Heartbeat(int64) error
+	Viewers(int64) (int, error)
+	Get(int64) (int, []*Chatter, error)
+}
+
+type Chatter struct {
+	ID   int64  `json:"user_id"`
+	Name string `json:"user_name"`
+}
+
+// PresenceModel counts the connections of each node separately, so the
+// connections of a node that dies without cleaning up stop counting once its
+// heartbeat runs out.
+type PresenceModel struct {
+	redisDB *redis.Client
+	node    string
+}
+
+func newNodeID() string {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(randomBytes)
+}
+
+// countScript is shared by the scripts below, it sums the connections of the
+// user over the nodes that are still alive.
+const countScript = `
+local function count(prefix, nodes, userID, alive)
+	local n = 0
+	for _, node in ipairs(redis.call('ZRANGEBYSCORE', nodes, alive, '+inf')) do
+		n = n + tonumber(redis.call('HGET', prefix .. ':connections:' .. node, userID) or 0)
+	end
+	return n
+end
+`
+
+// joinScript counts a new connection of the user on this node and reports
+// whether the user just came back from a leave that hasn't been announced yet.
+var joinScript = redis.NewScript(countScript + `
+local connections = ARGV[1] .. ':connections:' .. ARGV[2]
+local chatters = ARGV[1] .. ':chatters:' .. ARGV[2]
+redis.call('HINCRBY', connections, ARGV[3], 1)
+redis.call('PEXPIRE', connections, ARGV[6])
+if ARGV[4] ~= '' then
+	redis.call('HSET', chatters, ARGV[3], ARGV[4])
+	redis.call('PEXPIRE', chatters, ARGV[6])
+end
+redis.call('ZADD', KEYS[1], ARGV[5], ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[6])
+
+local n = count(ARGV[1], KEYS[1], ARGV[3], ARGV[5] - ARGV[6])
+local flapped = 0
+if n == 1 then
+	flapped = redis.call('DEL', KEYS[2])
+end
+return {n, flapped}
+`)
+
+// leaveScript removes a connection of the user on this node, marking the user
+// as leaving once it was the user's last one anywhere.
+var leaveScript = redis.NewScript(countScript + `
+local connections = ARGV[1] .. ':connections:' .. ARGV[2]
+local chatters = ARGV[1] .. ':chatters:' .. ARGV[2]
+if redis.call('HINCRBY', connections, ARGV[3], -1) <= 0 then
+	redis.call('HDEL', connections, ARGV[3])
+	redis.call('HDEL', chatters, ARGV[3])
+end
+if redis.call('EXISTS', connections) == 0 then
+	redis.call('ZREM', KEYS[1], ARGV[2])
+end
+
+local n = count(ARGV[1], KEYS[1], ARGV[3], ARGV[4] - ARGV[5])
+if n <= 0 and ARGV[3] ~= '0' then
+	redis.call('SET', KEYS[2], 1, 'PX', ARGV[6])
+end
+return n
+`)
+
+// heartbeatScript keeps this node's connections to the room counting.
+var heartbeatScript = redis.NewScript(`
+local connections = ARGV[1] .. ':connections:' .. ARGV[2]
+if redis.call('EXISTS', connections) == 0 then
+	return 0
+end
+redis.call('PEXPIRE', connections, ARGV[4])
+redis.call('PEXPIRE', ARGV[1] .. ':chatters:' .. ARGV[2], ARGV[4])
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return 1
+`)
+
+func presencePrefix(roomID int64) string {
+	return "room:" + strconv.FormatInt(roomID, 10)
+}
+
+// presenceKeys returns the set of nodes with connections to the room, scored by
+// their last heartbeat, and the user's leaving marker.
+func presenceKeys(roomID int64, user *User) []string {
+	room := presencePrefix(roomID)
+	return []string{room + ":nodes", room + ":leaving:" + strconv.FormatInt(user.ID, 10)}
+}
+
+// Join counts a new connection of the user to the room. It reports whether the
+// join should be announced, which is only for a logged-in user's first
+// connection and not when the user reconnects within the leave debounce.
+func (m PresenceModel) Join(roomID int64, user *User) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := joinScript.Run(ctx, m.redisDB, presenceKeys(roomID, user),
+		presencePrefix(roomID), m.node, user.ID, user.Name, time.Now().UnixMilli(), presenceTTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, err
+	}
+	return !user.IsAnonymous() && result[0] == 1 && result[1] == 0, nil
+}
+
+// Leave removes a connection of the user from the room. It reports whether it
+// was the user's last connection, in which case Left should be called once the
+// debounce has passed.
+func (m PresenceModel) Leave(roomID int64, user *User, debounce time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	n, err := leaveScript.Run(ctx, m.redisDB, presenceKeys(roomID, user),
+		presencePrefix(roomID), m.node, user.ID, time.Now().UnixMilli(), presenceTTL.Milliseconds(), (2 * debounce).Milliseconds()).Int64()
+	if err != nil {
+		return false, err
+	}
+	return !user.IsAnonymous() && n <= 0, nil
+}
+
+// Left reports whether the user is still gone after the leave debounce.
+func (m PresenceModel) Left(roomID int64, user *User) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	n, err := m.redisDB.Del(ctx, presenceKeys(roomID, user)[1]).Result()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Heartbeat keeps the connections of this node to the room counting for
+// another presenceTTL.
+func (m PresenceModel) Heartbeat(roomID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return heartbeatScript.Run(ctx, m.redisDB, presenceKeys(roomID, AnonymousUser)[:1],
+		presencePrefix(roomID), m.node, time.Now().UnixMilli(), presenceTTL.Milliseconds()).Err()
+}
+
+// nodes returns the nodes with connections to the room that are still alive,
+// dropping the ones whose heartbeat ran out.
+func (m PresenceModel) nodes(ctx context.Context, roomID int64) ([]string, error) {
+	key := presenceKeys(roomID, AnonymousUser)[0]
+	alive := time.Now().Add(-presenceTTL).UnixMilli()
+
+	if err := m.redisDB.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(alive, 10)).Err(); err != nil {
+		return nil, err
+	}
+	return m.redisDB.ZRange(ctx, key, 0, -1).Result()
+}
+
+// Viewers returns the number of connections to the room, anonymous ones included.
+func (m PresenceModel) Viewers(roomID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	nodes, err := m.nodes(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	viewers := 0
+	for _, node := range nodes {
+		counts, err := m.redisDB.HVals(ctx, presencePrefix(roomID)+":connections:"+node).Result()
+		if err != nil {
+			return 0, err
+		}
+		for _, count := range counts {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				return 0, err
+			}
+			viewers += n
+		}
+	}
+	return viewers, nil
+}
+
+// Get returns the number of viewers of the room and the logged-in users in it.
+func (m PresenceModel) Get(roomID int64) (int, []*Chatter, error) {
+	viewers, err := m.Viewers(roomID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	nodes, err := m.nodes(ctx, roomID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// A user connected through several nodes is listed once
+	names := make(map[string]string)
+	for _, node := range nodes {
+		chatters, err := m.redisDB.HGetAll(ctx, presencePrefix(roomID)+":chatters:"+node).Result()
+		if err != nil {
+			return 0, nil, err
+		}
+		for id, name := range chatters {
+			names[id] = name
+		}
+	}
+
+	chatters := []*Chatter{}
+	for id, name := range names {
+		userID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return 0, nil, err
+		}
+		chatters = append(chatters, &Chatter{ID: userID, Name: name})
+	}
+	sort.Slice(chatters, func(i, j int) bool {
+		return chatters[i].Name < chatters[j].Name
+	})
+	return viewers, chatters, nil
+}
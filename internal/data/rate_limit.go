@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+type RateLimitInterface interface {
+	Take(int64, int64, Bucket, Bucket) (time.Duration, error)
+}
+
+// Bucket is a token bucket refilled at Rate tokens a second up to Burst.
+type Bucket struct {
+	Rate  float64
+	Burst int
+}
+
+type RateLimitModel struct {
+	redisDB *redis.Client
+}
+
+// takeScript takes a token from both the user's bucket for the room and the
+// user's global bucket, or from neither if either is empty. It returns whether
+// the tokens were taken and otherwise how many milliseconds until they can be.
+var takeScript = redis.NewScript(`
+local now = tonumber(ARGV[5])
+local buckets = {}
+for i = 1, 2 do
+	local rate = tonumber(ARGV[i * 2 - 1])
+	local burst = tonumber(ARGV[i * 2])
+	local state = redis.call('HMGET', KEYS[i], 'tokens', 'ts')
+	local tokens = tonumber(state[1]) or burst
+	local ts = tonumber(state[2]) or now
+	tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+	buckets[i] = {rate = rate, burst = burst, tokens = tokens}
+end
+
+local allowed = 1
+local wait = 0
+for i = 1, 2 do
+	local b = buckets[i]
+	if b.tokens < 1 then
+		allowed = 0
+		wait = math.max(wait, math.ceil((1 - b.tokens) * 1000 / b.rate))
+	end
+end
+
+for i = 1, 2 do
+	local b = buckets[i]
+	if allowed == 1 then
+		b.tokens = b.tokens - 1
+	end
+	redis.call('HSET', KEYS[i], 'tokens', tostring(b.tokens), 'ts', now)
+	redis.call('PEXPIRE', KEYS[i], math.ceil(b.burst * 1000 / b.rate))
+end
+return {allowed, wait}
+`)
+
+func rateLimitKeys(roomID, userID int64) []string {
+	user := strconv.FormatInt(userID, 10)
+	return []string{"room:" + strconv.FormatInt(roomID, 10) + ":rate:" + user, "user:" + user + ":rate"}
+}
+
+// Take takes a token for the user from the room's bucket and the global one. It
+// returns how long the user has to wait if either bucket is empty.
+func (m RateLimitModel) Take(roomID, userID int64, room, global Bucket) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := takeScript.Run(ctx, m.redisDB, rateLimitKeys(roomID, userID),
+		room.Rate, room.Burst, global.Rate, global.Burst, time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if result[0] == 1 {
+		return 0, nil
+	}
+	return time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,276 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"time"
+)
+
+var ErrDuplicateReport = errors.New("duplicate report")
+
+var ReportCategories = []string{"spam", "harassment", "hate", "sexual", "violence", "self_harm", "other"}
+
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+const (
+	ResolutionDismiss       = "dismiss"
+	ResolutionDeleteMessage = "delete_message"
+	ResolutionTimeout       = "timeout"
+	ResolutionBan           = "ban"
+	ResolutionShadowBan     = "shadow_ban"
+)
+
+type ReportInterface interface {
+	Insert(*Report, *ReportReason) error
+	GetAll(int64, ReportFilter) ([]*Report, ReportCursor, error)
+	Get(int64, int64) (*Report, error)
+	Resolve(*Report) error
+	Reopen(*Report) error
+}
+
+// Report is every open report of the same message, or of the same user when no
+// message is given, in a channel. Snapshot is the message as it was when it was
+// first reported.
+type Report struct {
+	ID           int64          `json:"report_id"`
+	ChannelID    int64          `json:"channel_id"`
+	TargetUserID int64          `json:"target_user_id"`
+	MessageID    int64          `json:"message_id,string,omitempty"`
+	Snapshot     []byte         `json:"snapshot,omitempty"`
+	Status       string         `json:"status"`
+	Resolution   string         `json:"resolution,omitempty"`
+	ResolvedBy   int64          `json:"resolved_by,omitempty"`
+	ResolvedAt   *time.Time     `json:"resolved_at,omitempty"`
+	ReportCount  int            `json:"report_count"`
+	Categories   map[string]int `json:"categories"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+type ReportReason struct {
+	ReporterID int64  `json:"reporter_id"`
+	Category   string `json:"category"`
+	Comment    string `json:"comment"`
+}
+
+type ReportFilter struct {
+	Status   string
+	BeforeID int64
+	Limit    int
+}
+
+type ReportCursor struct {
+	Before  int64 `json:"before_id,omitempty"`
+	HasMore bool  `json:"has_more"`
+}
+
+type ReportModel struct {
+	db *sql.DB
+}
+
+const reportColumns = `
+	id, channel_id, target_user_id, COALESCE(message_id, 0), snapshot, status, COALESCE(resolution, ''), COALESCE(resolved_by, 0), resolved_at,
+	(SELECT COUNT(*) FROM report_reasons WHERE report_id = reports.id),
+	(SELECT COALESCE(jsonb_object_agg(category, n), '{}') FROM (
+		SELECT category, COUNT(*) AS n FROM report_reasons WHERE report_id = reports.id GROUP BY category
+	) AS categories),
+	created_at, updated_at`
+
+func scanReport(row interface{ Scan(...any) error }) (*Report, error) {
+	var (
+		report     Report
+		categories []byte
+	)
+	if err := row.Scan(&report.ID, &report.ChannelID, &report.TargetUserID, &report.MessageID, &report.Snapshot, &report.Status, &report.Resolution,
+		&report.ResolvedBy, &report.ResolvedAt, &report.ReportCount, &categories, &report.CreatedAt, &report.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(categories, &report.Categories); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Insert files the report, adding it to the open report of the same message or
+// user if there is one. Users can only report the same thing once.
+func (m ReportModel) Insert(report *Report, reason *ReportReason) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	var messageID sql.NullInt64
+	if report.MessageID > 0 {
+		messageID = sql.NullInt64{Int64: report.MessageID, Valid: true}
+	}
+
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO reports (channel_id, target_user_id, message_id, snapshot) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (channel_id, target_user_id, (COALESCE(message_id, 0))) WHERE status = 'open'
+		DO UPDATE SET updated_at = NOW()
+		RETURNING id`,
+		report.ChannelID, report.TargetUserID, messageID, report.Snapshot).
+		Scan(&report.ID); err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO report_reasons (report_id, reporter_id, category, comment) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+		report.ID, reason.ReporterID, reason.Category, reason.Comment)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrDuplicateReport
+	}
+
+	saved, err := scanReport(tx.QueryRowContext(ctx, "SELECT "+reportColumns+" FROM reports WHERE id = $1", report.ID))
+	if err != nil {
+		return err
+	}
+	*report = *saved
+
+	return tx.Commit()
+}
+
+// GetAll returns a page of the channel's reports with the given status, the
+// most recently reported first.
+func (m ReportModel) GetAll(channelID int64, filter ReportFilter) ([]*Report, ReportCursor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT `+reportColumns+` FROM reports
+		WHERE channel_id = $1
+		AND ($2 = '' OR status = $2)
+		AND ($3::bigint = 0 OR id < $3::bigint)
+		ORDER BY id DESC LIMIT $4`,
+		channelID, filter.Status, filter.BeforeID, filter.Limit+1)
+	if err != nil {
+		return nil, ReportCursor{}, err
+	}
+	defer func(rows *sql.Rows) {
+		if err := rows.Close(); err != nil {
+			return
+		}
+	}(rows)
+
+	reports := []*Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, ReportCursor{}, err
+		}
+		reports = append(reports, report)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, ReportCursor{}, err
+	}
+
+	var cursor ReportCursor
+	if len(reports) > filter.Limit {
+		reports = reports[:filter.Limit]
+		cursor.HasMore = true
+	}
+	if len(reports) > 0 {
+		cursor.Before = reports[len(reports)-1].ID
+	}
+	return reports, cursor, nil
+}
+
+func (m ReportModel) Get(channelID, reportID int64) (*Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	report, err := scanReport(m.db.QueryRowContext(ctx, "SELECT "+reportColumns+" FROM reports WHERE channel_id = $1 AND id = $2", channelID, reportID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// Resolve closes an open report with its status, resolution and resolver. It
+// fails with ErrEditConflict if someone else resolved it first.
+func (m ReportModel) Resolve(report *Report) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.db.QueryRowContext(ctx, `
+		UPDATE reports SET status = $1, resolution = $2, resolved_by = $3, resolved_at = NOW(), updated_at = NOW()
+		WHERE id = $4 AND status = 'open'
+		RETURNING resolved_at, updated_at`,
+		report.Status, report.Resolution, report.ResolvedBy, report.ID).
+		Scan(&report.ResolvedAt, &report.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Reopen gives back a report resolved by Resolve, for when its resolution could
+// not be carried out. It leaves the report alone if it has changed since.
+func (m ReportModel) Reopen(report *Report) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.db.QueryRowContext(ctx, `
+		UPDATE reports SET status = 'open', resolution = NULL, resolved_by = NULL, resolved_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status = $2 AND resolved_by = $3 AND updated_at = $4
+		RETURNING updated_at`,
+		report.ID, report.Status, report.ResolvedBy, report.UpdatedAt).
+		Scan(&report.UpdatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	report.Status, report.Resolution, report.ResolvedBy, report.ResolvedAt = ReportOpen, "", 0, nil
+	return nil
+}
+
+func ValidateReportReason(v *validator.Validator, reason *ReportReason) {
+	v.Check(validator.In(reason.Category, ReportCategories...), "category", "must be one of spam, harassment, hate, sexual, violence, self_harm or other")
+	v.Check(len(reason.Comment) <= 1000, "comment", "must not be more than 1000 bytes long")
+}
+
+func ValidateReportFilter(v *validator.Validator, filter ReportFilter) {
+	v.Check(filter.Status == "" || validator.In(filter.Status, ReportOpen, ReportResolved, ReportDismissed), "status", "must be open, resolved or dismissed")
+	v.Check(filter.BeforeID >= 0, "before_id", "must be a positive integer")
+	v.Check(filter.Limit > 0, "limit", "must be greater than zero")
+	v.Check(filter.Limit <= 100, "limit", "must be a maximum of 100")
+}
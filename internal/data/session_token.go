@@ -5,15 +5,27 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/JunJie-Lai/Chat-App/internal/validator"
 	"github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
 	"time"
 )
 
 type SessionTokenInterface interface {
-	New(*User, time.Duration) (*SessionToken, error)
+	NewSession(*User, time.Duration, *Session) (*SessionToken, error)
 	Set(*User, *SessionToken) error
+	Refresh(*User) error
+	RefreshPermissions(int64, Permissions) error
+	Touch(int64, string) error
+	Exists(int64, string) (bool, error)
+	GetAllForUser(int64) ([]*Session, error)
 	Delete(string) error
+	DeleteSession(int64, string) error
+	DeleteAllForUser(int64, string) ([]string, error)
 }
 
 type SessionToken struct {
@@ -21,6 +33,19 @@ type SessionToken struct {
 	Hash      []byte    `json:"-"`
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
+	SessionID string    `json:"session_id,omitempty"`
+}
+
+// Session is where a user is logged in. Its ID is the hex encoded hash of its
+// token, which can't be used to log in.
+type Session struct {
+	ID        string    `json:"session_id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expiry    time.Time `json:"expiry"`
+	Current   bool      `json:"current"`
 }
 
 type SessionTokenModel struct {
@@ -41,28 +66,107 @@ func generateToken(userID int64, ttl time.Duration) (*SessionToken, error) {
 	}
 
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Hash = hashToken(token.Plaintext)
 
 	return token, nil
 }
 
+func hashToken(tokenPlaintext string) []byte {
+	hash := sha256.Sum256([]byte(tokenPlaintext))
+	return hash[:]
+}
+
+// SessionID returns the ID of the session of a token.
+func SessionID(tokenPlaintext string) string {
+	return hex.EncodeToString(hashToken(tokenPlaintext))
+}
+
+// sessionKey returns the key of the session's token, the raw hash the ID encodes.
+func sessionKey(sessionID string) (string, error) {
+	hash, err := hex.DecodeString(sessionID)
+	if err != nil || len(hash) != sha256.Size {
+		return "", ErrRecordNotFound
+	}
+	return string(hash), nil
+}
+
+// userSessionsKey indexes the user's sessions by their ID, so they can be
+// listed, revoked and have the cached user refreshed.
+func userSessionsKey(userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10) + ":sessions"
+}
+
+// userSessionsSeenKey holds when each of the user's sessions was last used,
+// apart from the index as it changes on every request.
+func userSessionsSeenKey(userID int64) string {
+	return userSessionsKey(userID) + ":seen"
+}
+
+// refreshScript replaces the user cached under a session token, unless the
+// session has expired or been deleted in the meantime.
+var refreshScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], unpack(ARGV))
+return 1
+`)
+
+// touchScript records when a session in the index was last used, living as
+// long as the index does.
+var touchScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('PEXPIRE', KEYS[2], redis.call('PTTL', KEYS[1]))
+return 1
+`)
+
 func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(tokenPlaintext != "", "token", "must be provided")
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
-func (m SessionTokenModel) New(user *User, ttl time.Duration) (*SessionToken, error) {
+// NewSession logs the user in, adding the session to the user's index.
+func (m SessionTokenModel) NewSession(user *User, ttl time.Duration, session *Session) (*SessionToken, error) {
 	token, err := generateToken(user.ID, ttl)
 	if err != nil {
 		return nil, err
 	}
+	token.SessionID = hex.EncodeToString(token.Hash)
 
 	if err := m.Set(user, token); err != nil {
 		return nil, err
 	}
 
-	return token, err
+	session.ID = token.SessionID
+	session.CreatedAt = time.Now()
+	session.LastSeen = session.CreatedAt
+	session.Expiry = token.Expiry
+
+	js, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	index := userSessionsKey(user.ID)
+	seen := userSessionsSeenKey(user.ID)
+	pipe := m.redisDB.TxPipeline()
+	pipe.HSet(ctx, index, session.ID, js)
+	pipe.HSet(ctx, seen, session.ID, session.LastSeen)
+	// The index lives as long as the user's newest session
+	for _, key := range []string{index, seen} {
+		pipe.ExpireNX(ctx, key, ttl)
+		pipe.ExpireGT(ctx, key, ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return token, nil
 }
 
 func (m SessionTokenModel) Set(user *User, token *SessionToken) error {
@@ -72,12 +176,163 @@ func (m SessionTokenModel) Set(user *User, token *SessionToken) error {
 	return m.redisDB.ExpireAt(ctx, string(token.Hash), token.Expiry).Err()
 }
 
+// Refresh updates the user cached under every one of the user's session tokens,
+// for when the user has changed.
+func (m SessionTokenModel) Refresh(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	index := userSessionsKey(user.ID)
+	sessionIDs, err := m.redisDB.HKeys(ctx, index).Result()
+	if err != nil {
+		return err
+	}
+
+	fields := []any{"user_id", user.ID, "user_name", user.Name, "email", user.Email, "created_at", user.CreatedAt, "activated", user.Activated, "version", user.Version}
+	for _, sessionID := range sessionIDs {
+		key, err := sessionKey(sessionID)
+		if err != nil {
+			continue
+		}
+		refreshed, err := refreshScript.Run(ctx, m.redisDB, []string{key}, fields...).Int()
+		if err != nil {
+			return err
+		}
+		if refreshed == 0 {
+			if err := m.redisDB.HDel(ctx, index, sessionID).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RefreshPermissions updates the permissions cached under every one of the
+// user's session tokens, for when the user's roles have changed.
+func (m SessionTokenModel) RefreshPermissions(userID int64, permissions Permissions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	sessionIDs, err := m.redisDB.HKeys(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		key, err := sessionKey(sessionID)
+		if err != nil {
+			continue
+		}
+		if err := refreshScript.Run(ctx, m.redisDB, []string{key}, "permissions", permissions).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Touch records that the session was just used.
+func (m SessionTokenModel) Touch(userID int64, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	keys := []string{userSessionsKey(userID), userSessionsSeenKey(userID)}
+	return touchScript.Run(ctx, m.redisDB, keys, sessionID, time.Now()).Err()
+}
+
+// Exists reports whether the user is still logged in with the session, it is
+// gone once the session has expired, been logged out or revoked.
+func (m SessionTokenModel) Exists(userID int64, sessionID string) (bool, error) {
+	key, err := sessionKey(sessionID)
+	if err != nil {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	owner, err := m.redisDB.HGet(ctx, key, "user_id").Int64()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return owner == userID, nil
+}
+
+// GetAllForUser returns the sessions the user is logged in with, the most
+// recently used first. Sessions that have expired are dropped from the index.
+func (m SessionTokenModel) GetAllForUser(userID int64) ([]*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	index := userSessionsKey(userID)
+	seen := userSessionsSeenKey(userID)
+
+	entries, err := m.redisDB.HGetAll(ctx, index).Result()
+	if err != nil {
+		return nil, err
+	}
+	lastSeen, err := m.redisDB.HGetAll(ctx, seen).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*Session{}
+	for sessionID, entry := range entries {
+		key, err := sessionKey(sessionID)
+		if err != nil {
+			continue
+		}
+		exists, err := m.redisDB.Exists(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			if err := m.redisDB.HDel(ctx, index, sessionID).Err(); err != nil {
+				return nil, err
+			}
+			if err := m.redisDB.HDel(ctx, seen, sessionID).Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var session Session
+		if err := json.Unmarshal([]byte(entry), &session); err != nil {
+			return nil, err
+		}
+		if t, err := time.Parse(time.RFC3339Nano, lastSeen[sessionID]); err == nil {
+			session.LastSeen = t
+		}
+		sessions = append(sessions, &session)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen.After(sessions[j].LastSeen)
+	})
+	return sessions, nil
+}
+
+// Delete logs the token's session out.
 func (m SessionTokenModel) Delete(tokenPlaintext string) error {
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+	tokenHash := hashToken(tokenPlaintext)
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.redisDB.Del(ctx, string(tokenHash[:])).Result()
+	userID, err := m.redisDB.HGet(ctx, string(tokenHash), "user_id").Int64()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	result, err := m.redisDB.Del(ctx, string(tokenHash)).Result()
 	if err != nil {
 		return err
 	}
@@ -85,5 +340,70 @@ func (m SessionTokenModel) Delete(tokenPlaintext string) error {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	sessionID := hex.EncodeToString(tokenHash)
+	if err := m.redisDB.HDel(ctx, userSessionsKey(userID), sessionID).Err(); err != nil {
+		return err
+	}
+	return m.redisDB.HDel(ctx, userSessionsSeenKey(userID), sessionID).Err()
+}
+
+// DeleteSession logs one of the user's sessions out.
+func (m SessionTokenModel) DeleteSession(userID int64, sessionID string) error {
+	key, err := sessionKey(sessionID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	removed, err := m.redisDB.HDel(ctx, userSessionsKey(userID), sessionID).Result()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrRecordNotFound
+	}
+
+	pipe := m.redisDB.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HDel(ctx, userSessionsSeenKey(userID), sessionID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DeleteAllForUser logs the user out of every session but the one to keep, if
+// any, and returns the IDs of the sessions it logged out.
+func (m SessionTokenModel) DeleteAllForUser(userID int64, keep string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	index := userSessionsKey(userID)
+	seen := userSessionsSeenKey(userID)
+
+	sessionIDs, err := m.redisDB.HKeys(ctx, index).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	pipe := m.redisDB.TxPipeline()
+	for _, sessionID := range sessionIDs {
+		if sessionID == keep {
+			continue
+		}
+		pipe.HDel(ctx, index, sessionID)
+		pipe.HDel(ctx, seen, sessionID)
+		if key, err := sessionKey(sessionID); err == nil {
+			pipe.Del(ctx, key)
+		}
+		deleted = append(deleted, sessionID)
+	}
+	if len(deleted) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return deleted, nil
 }
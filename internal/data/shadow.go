@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+type ShadowMessageInterface interface {
+	Add(*Message, HistorySettings) error
+	Get(int64, int) ([]*Message, error)
+	GetAfter(int64, int64, int64) ([]*Message, error)
+}
+
+// ShadowMessageModel keeps the messages of shadow banned users apart from the
+// room's history, where only moderators and the users themselves see them.
+type ShadowMessageModel struct {
+	redisDB *redis.Client
+}
+
+func shadowKey(roomID int64) string {
+	return "room:" + strconv.FormatInt(roomID, 10) + ":shadowed"
+}
+
+// Add keeps the message for as long as the room keeps its history.
+func (m ShadowMessageModel) Add(message *Message, history HistorySettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	msg, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	key := shadowKey(message.RoomID)
+	maxAge := time.Duration(history.MaxAge) * time.Second
+
+	pipe := m.redisDB.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(message.ID), Member: msg})
+	pipe.ZRemRangeByRank(ctx, key, 0, int64(-history.Limit-1))
+	pipe.Expire(ctx, key, maxAge)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Get returns up to the last n shadowed messages of the room, oldest first.
+func (m ShadowMessageModel) Get(roomID int64, n int) ([]*Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.redisDB.ZRange(ctx, shadowKey(roomID), int64(-n), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeHistory(roomID, result)
+}
+
+// GetAfter returns the shadowed messages of the user in the room that came
+// after the message with the given ID, oldest first.
+func (m ShadowMessageModel) GetAfter(roomID, userID, messageID int64) ([]*Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.redisDB.ZRangeByScore(ctx, shadowKey(roomID), &redis.ZRangeBy{Min: "(" + strconv.FormatInt(messageID, 10), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	shadowed, err := decodeHistory(roomID, result)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*Message
+	for _, message := range shadowed {
+		if message.UserID == userID {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/JunJie-Lai/Chat-App/internal/validator"
+	"time"
+)
+
+type SuspensionInterface interface {
+	Get(int64) (*Suspension, error)
+	Suspend(*Suspension) error
+	Unsuspend(int64) error
+}
+
+// Suspension keeps a user from logging in anywhere on the site. Suspensions
+// without an expiry last until they are lifted.
+type Suspension struct {
+	UserID    int64      `json:"user_id"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedBy int64      `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type SuspensionModel struct {
+	db *sql.DB
+}
+
+// Get returns the user's suspension as long as it is still in force.
+func (m SuspensionModel) Get(userID int64) (*Suspension, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var suspension Suspension
+	if err := m.db.QueryRowContext(ctx, `
+		SELECT user_id, reason, expires_at, created_by, created_at FROM user_suspensions
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())`, userID).
+		Scan(&suspension.UserID, &suspension.Reason, &suspension.ExpiresAt, &suspension.CreatedBy, &suspension.CreatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &suspension, nil
+}
+
+// Suspend suspends the user, replacing any earlier suspension of the user.
+func (m SuspensionModel) Suspend(suspension *Suspension) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if err := m.db.QueryRowContext(ctx, `
+		INSERT INTO user_suspensions (user_id, reason, expires_at, created_by) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET reason = EXCLUDED.reason, expires_at = EXCLUDED.expires_at, created_by = EXCLUDED.created_by, created_at = NOW()
+		RETURNING created_at`,
+		suspension.UserID, suspension.Reason, suspension.ExpiresAt, suspension.CreatedBy).
+		Scan(&suspension.CreatedAt); err != nil {
+		switch {
+		case isForeignKeyViolation(err):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SuspensionModel) Unsuspend(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx,
+		"DELETE FROM user_suspensions WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())", userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func ValidateSuspension(v *validator.Validator, suspension *Suspension) {
+	v.Check(suspension.UserID > 0, "user_id", "must be provided")
+	v.Check(len(suspension.Reason) <= 500, "reason", "must not be more than 500 bytes long")
+	if suspension.ExpiresAt != nil {
+		v.Check(suspension.ExpiresAt.After(time.Now()), "duration", "must be greater than zero")
+	}
+}
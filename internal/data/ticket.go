@@ -0,0 +1,113 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ScopeWebsocket = "websocket"
+
+// TicketPrefix starts every ticket, so a ticket is never mistaken for a session
+// token.
+const TicketPrefix = "wst_"
+
+type TicketInterface interface {
+	New(*User, int64, string, time.Duration, string) (*Ticket, error)
+	Consume(string, int64, string) (*Ticket, error)
+}
+
+// Ticket lets its user into a single channel for a single purpose, its scope.
+// It can only be used once and, unlike a session token, authenticates nothing
+// but that.
+type Ticket struct {
+	Plaintext string    `json:"ticket"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	ChannelID int64     `json:"-"`
+	SessionID string    `json:"-"`
+	Scope     string    `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+type TicketModel struct {
+	redisDB *redis.Client
+}
+
+func ticketKey(scope string, hash []byte) string {
+	return "ticket:" + scope + ":" + string(hash)
+}
+
+// consumeTicketScript reads and deletes a ticket in one go, so two handshakes
+// racing with the same ticket can't both get it.
+var consumeTicketScript = redis.NewScript(`
+local ticket = redis.call('HGETALL', KEYS[1])
+redis.call('DEL', KEYS[1])
+return ticket
+`)
+
+// New issues the user a ticket for the channel, belonging to the session the
+// user asked for it with.
+func (m TicketModel) New(user *User, channelID int64, sessionID string, ttl time.Duration, scope string) (*Ticket, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return nil, err
+	}
+
+	ticket := &Ticket{
+		Plaintext: TicketPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		UserID:    user.ID,
+		ChannelID: channelID,
+		SessionID: sessionID,
+		Scope:     scope,
+		Expiry:    time.Now().Add(ttl),
+	}
+	ticket.Hash = hashToken(ticket.Plaintext)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key := ticketKey(scope, ticket.Hash)
+	pipe := m.redisDB.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", ticket.UserID, "channel_id", ticket.ChannelID, "session_id", ticket.SessionID)
+	pipe.ExpireAt(ctx, key, ticket.Expiry)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return ticket, nil
+}
+
+// Consume uses up the ticket of the scope, as long as it was issued for the
+// channel. A ticket for another channel is used up all the same.
+func (m TicketModel) Consume(scope string, channelID int64, ticketPlaintext string) (*Ticket, error) {
+	if !strings.HasPrefix(ticketPlaintext, TicketPrefix) {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash := hashToken(ticketPlaintext)
+	fields, err := consumeTicketScript.Run(ctx, m.redisDB, []string{ticketKey(scope, hash)}).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		values[fields[i]] = fields[i+1]
+	}
+
+	ticket := &Ticket{Plaintext: ticketPlaintext, Hash: hash, SessionID: values["session_id"], Scope: scope}
+	if ticket.UserID, err = strconv.ParseInt(values["user_id"], 10, 64); err != nil {
+		return nil, ErrRecordNotFound
+	}
+	if ticket.ChannelID, err = strconv.ParseInt(values["channel_id"], 10, 64); err != nil || ticket.ChannelID != channelID {
+		return nil, ErrRecordNotFound
+	}
+	return ticket, nil
+}
@@ -0,0 +1,106 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+const (
+	ScopeActivation    = "activation"
+	ScopePasswordReset = "password-reset"
+)
+
+type TokenInterface interface {
+	New(int64, time.Duration, string) (*Token, error)
+	Consume(string, string) (int64, error)
+	DeleteAllForUser(string, int64) error
+}
+
+// Token is a one-time token sent to a user for a single purpose, its scope.
+// Like session tokens only their hash is kept, but they map to the ID of the
+// user rather than to the user itself and can only be used once.
+type Token struct {
+	Plaintext string    `json:"token"`
+	Hash      []byte    `json:"-"`
+	UserID    int64     `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+type TokenModel struct {
+	redisDB *redis.Client
+}
+
+func tokenKey(scope string, hash []byte) string {
+	return "token:" + scope + ":" + string(hash)
+}
+
+// userTokensKey holds the hashes of the user's tokens of the scope, so they can
+// all be revoked together.
+func userTokensKey(scope string, userID int64) string {
+	return "user:" + strconv.FormatInt(userID, 10) + ":tokens:" + scope
+}
+
+func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+	session, err := generateToken(userID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{Plaintext: session.Plaintext, Hash: session.Hash, UserID: userID, Expiry: session.Expiry, Scope: scope}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	index := userTokensKey(scope, userID)
+	pipe := m.redisDB.TxPipeline()
+	pipe.Set(ctx, tokenKey(scope, token.Hash), userID, ttl)
+	pipe.SAdd(ctx, index, string(token.Hash))
+	pipe.ExpireAt(ctx, index, token.Expiry)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Consume uses up the token of the scope and returns the ID of its user.
+func (m TokenModel) Consume(scope, tokenPlaintext string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hash := hashToken(tokenPlaintext)
+	userID, err := m.redisDB.GetDel(ctx, tokenKey(scope, hash)).Int64()
+	if err != nil {
+		switch {
+		case errors.Is(err, redis.Nil):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	if err := m.redisDB.SRem(ctx, userTokensKey(scope, userID), string(hash)).Err(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// DeleteAllForUser revokes every token of the scope the user still has.
+func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	index := userTokensKey(scope, userID)
+	hashes, err := m.redisDB.SMembers(ctx, index).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{index}
+	for _, hash := range hashes {
+		keys = append(keys, tokenKey(scope, []byte(hash)))
+	}
+	return m.redisDB.Del(ctx, keys...).Err()
+}
@@ -19,15 +19,25 @@ var (
 type UserInterface interface {
 	Insert(*User) error
 	GetByEmail(string) (*User, error)
-	Update(string, *User) error
+	GetByID(int64) (*User, error)
+	Update(*User) error
+	UpdatePassword(*User) error
+	Activate(int64) (*User, error)
 	GetFromToken(string) (*User, error)
 }
 
 type User struct {
-	ID       int64    `json:"user_id" redis:"user_id"`
-	Name     string   `json:"user_name" redis:"user_name"`
-	Email    string   `json:"email" redis:"email"`
-	Password password `json:"-" redis:"-"`
+	ID        int64     `json:"user_id" redis:"user_id"`
+	Name      string    `json:"user_name" redis:"user_name"`
+	Email     string    `json:"email" redis:"email"`
+	Password  password  `json:"-" redis:"-"`
+	CreatedAt time.Time `json:"created_at" redis:"created_at"`
+	Activated bool      `json:"activated" redis:"activated"`
+	Version   int       `json:"version" redis:"version"`
+
+	// Permissions are loaded on login and cached with the user, they are
+	// refreshed whenever the user's roles change
+	Permissions Permissions `json:"permissions,omitempty" redis:"permissions"`
 }
 
 type password struct {
@@ -45,8 +55,8 @@ func (m UserModel) Insert(user *User) error {
 	defer cancel()
 
 	if err := m.db.QueryRowContext(ctx,
-		"INSERT INTO users (name, email, password_hash) VALUES ($1, $2, $3) RETURNING id",
-		&user.Name, &user.Email, &user.Password.hash).Scan(&user.ID); err != nil {
+		"INSERT INTO users (name, email, password_hash, activated) VALUES ($1, $2, $3, $4) RETURNING id, created_at, version",
+		&user.Name, &user.Email, &user.Password.hash, &user.Activated).Scan(&user.ID, &user.CreatedAt, &user.Version); err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
@@ -63,8 +73,8 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 	var user User
 
-	if err := m.db.QueryRowContext(ctx, "SELECT * FROM users WHERE email = $1", email).
-		Scan(&user.ID, &user.Name, &user.Email, &user.Password.hash); err != nil {
+	if err := m.db.QueryRowContext(ctx, "SELECT id, name, email, password_hash, created_at, activated, version FROM users WHERE email = $1", email).
+		Scan(&user.ID, &user.Name, &user.Email, &user.Password.hash, &user.CreatedAt, &user.Activated, &user.Version); err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
@@ -75,13 +85,34 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (m UserModel) Update(tokenPlaintext string, user *User) error {
+func (m UserModel) GetByID(userID int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user := User{ID: userID}
+
+	if err := m.db.QueryRowContext(ctx, "SELECT name, email, password_hash, created_at, activated, version FROM users WHERE id = $1", userID).
+		Scan(&user.Name, &user.Email, &user.Password.hash, &user.CreatedAt, &user.Activated, &user.Version); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// Update saves the user as long as nobody else changed it since it was read,
+// otherwise it returns ErrEditConflict. The users cached under its session
+// tokens are left to SessionToken.Refresh.
+func (m UserModel) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	if err := m.db.QueryRowContext(ctx,
-		"UPDATE users SET name = $1, email = $2, password_hash = $3 WHERE id = $4 RETURNING id",
-		user.Name, user.Email, user.Password.hash, user.ID).Scan(&user.ID); err != nil {
+		"UPDATE users SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1 WHERE id = $5 AND version = $6 RETURNING version",
+		user.Name, user.Email, user.Password.hash, user.Activated, user.ID, user.Version).Scan(&user.Version); err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
@@ -91,12 +122,49 @@ func (m UserModel) Update(tokenPlaintext string, user *User) error {
 			return err
 		}
 	}
+	return nil
+}
 
-	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
-	m.redisDB.HSet(ctx, string(tokenHash[:]), user)
+// UpdatePassword stores the new password of the user, it doesn't touch the
+// user's sessions.
+func (m UserModel) UpdatePassword(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.db.ExecContext(ctx, "UPDATE users SET password_hash = $1, version = version + 1 WHERE id = $2", user.Password.hash, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
 	return nil
 }
 
+func (m UserModel) Activate(userID int64) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	user := User{ID: userID}
+	if err := m.db.QueryRowContext(ctx,
+		"UPDATE users SET activated = TRUE, version = version + 1 WHERE id = $1 RETURNING name, email, password_hash, created_at, activated, version", userID).
+		Scan(&user.Name, &user.Email, &user.Password.hash, &user.CreatedAt, &user.Activated, &user.Version); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m UserModel) GetFromToken(tokenPlaintext string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
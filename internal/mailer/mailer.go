@@ -0,0 +1,160 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	tt "text/template"
+	"time"
+)
+
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends the email made from the template file to the recipient, with
+// data filled into the template.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// SMTP sends emails through an SMTP server.
+type SMTP struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+func NewSMTP(host string, port int, username, password, sender string) *SMTP {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTP{addr: host + ":" + strconv.Itoa(port), auth: auth, sender: sender}
+}
+
+func (m *SMTP) Send(recipient, templateFile string, data any) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	// Retry a couple of times before giving up, SMTP servers can be flaky
+	for i := 1; i <= 3; i++ {
+		if err = smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, msg); err == nil {
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return err
+}
+
+// Log stands in for a real mailer during development. It logs the emails, and
+// writes them as .eml files into dir when it is set.
+type Log struct {
+	logger *slog.Logger
+	dir    string
+	sender string
+}
+
+func NewLog(logger *slog.Logger, dir, sender string) *Log {
+	return &Log{logger: logger, dir: dir, sender: sender}
+}
+
+func (m *Log) Send(recipient, templateFile string, data any) error {
+	msg, err := render(m.sender, recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	if m.dir == "" {
+		m.logger.Info("email not sent", "recipient", recipient, "template", templateFile, "message", string(msg))
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(m.dir, fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.TrimSuffix(templateFile, filepath.Ext(templateFile))))
+	if err := os.WriteFile(name, msg, 0o644); err != nil {
+		return err
+	}
+	m.logger.Info("email written", "recipient", recipient, "template", templateFile, "file", name)
+	return nil
+}
+
+// render executes the subject, plainBody and htmlBody templates of the template
+// file into a multipart email.
+func render(sender, recipient, templateFile string, data any) ([]byte, error) {
+	textTmpl, err := tt.New("").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := textTmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	if err := textTmpl.ExecuteTemplate(plainBody, "plainBody", data); err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := template.New("").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	if err := htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data); err != nil {
+		return nil, err
+	}
+
+	body := new(bytes.Buffer)
+	parts := multipart.NewWriter(body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=UTF-8", plainBody.Bytes()},
+		{"text/html; charset=UTF-8", htmlBody.Bytes()},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", sender)
+	fmt.Fprintf(msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(msg, "Subject: %s\r\n", strings.TrimSpace(subject.String()))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS messages
+(
+    id         BIGSERIAL PRIMARY KEY,
+    channel_id BIGINT                   NOT NULL,
+    user_id    BIGINT                   NOT NULL,
+    username   VARCHAR(32)              NOT NULL,
+    message    bytea                    NOT NULL,
+    super_chat BOOLEAN                  NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (channel_id) REFERENCES channel (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS messages_channel_id_created_at_idx ON messages (channel_id, created_at)
\ No newline at end of file
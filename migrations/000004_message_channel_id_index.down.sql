@@ -0,0 +1 @@
+DROP INDEX IF EXISTS messages_channel_id_id_idx;
\ No newline at end of file
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS messages_channel_id_id_idx ON messages (channel_id, id)
\ No newline at end of file
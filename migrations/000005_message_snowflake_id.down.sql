@@ -0,0 +1,2 @@
+CREATE SEQUENCE IF NOT EXISTS messages_id_seq OWNED BY messages.id;
+ALTER TABLE messages ALTER COLUMN id SET DEFAULT nextval('messages_id_seq');
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE messages ALTER COLUMN id DROP DEFAULT;
+DROP SEQUENCE IF EXISTS messages_id_seq
\ No newline at end of file
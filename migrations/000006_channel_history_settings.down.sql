@@ -0,0 +1,4 @@
+ALTER TABLE channel
+    DROP COLUMN IF EXISTS history_limit,
+    DROP COLUMN IF EXISTS history_max_age,
+    DROP COLUMN IF EXISTS history_replay;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE channel
+    ADD COLUMN IF NOT EXISTS history_limit   INTEGER NOT NULL DEFAULT 1000,
+    ADD COLUMN IF NOT EXISTS history_max_age INTEGER NOT NULL DEFAULT 86400,
+    ADD COLUMN IF NOT EXISTS history_replay  INTEGER NOT NULL DEFAULT 50
\ No newline at end of file
@@ -0,0 +1,16 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS edited_at  TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS message_revisions
+(
+    id         BIGSERIAL PRIMARY KEY,
+    message_id BIGINT                   NOT NULL,
+    message    bytea                    NOT NULL,
+    edited_by  BIGINT                   NOT NULL,
+    deleted    BOOLEAN                  NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS message_revisions_message_id_idx ON message_revisions (message_id)
\ No newline at end of file
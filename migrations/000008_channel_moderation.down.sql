@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS channel_bans;
+DROP TABLE IF EXISTS channel_moderators;
\ No newline at end of file
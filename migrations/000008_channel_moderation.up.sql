@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS channel_moderators
+(
+    channel_id BIGINT                   NOT NULL,
+    user_id    BIGINT                   NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (channel_id, user_id),
+    FOREIGN KEY (channel_id) REFERENCES channel (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS channel_bans
+(
+    channel_id BIGINT                   NOT NULL,
+    user_id    BIGINT                   NOT NULL,
+    reason     TEXT                     NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_by BIGINT                   NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (channel_id, user_id),
+    FOREIGN KEY (channel_id) REFERENCES channel (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+)
\ No newline at end of file
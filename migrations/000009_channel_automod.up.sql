@@ -0,0 +1,2 @@
+ALTER TABLE channel
+    ADD COLUMN IF NOT EXISTS automod JSONB NOT NULL DEFAULT '{"action": "block", "links": "allow"}'
\ No newline at end of file
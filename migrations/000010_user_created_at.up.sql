@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS channel_followers
\ No newline at end of file
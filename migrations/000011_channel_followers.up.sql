@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS channel_followers
+(
+    channel_id BIGINT                   NOT NULL,
+    user_id    BIGINT                   NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (channel_id, user_id),
+    FOREIGN KEY (channel_id) REFERENCES channel (id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+)
\ No newline at end of file
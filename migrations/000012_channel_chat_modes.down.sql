@@ -0,0 +1,2 @@
+ALTER TABLE channel
+    DROP COLUMN IF EXISTS modes
\ No newline at end of file
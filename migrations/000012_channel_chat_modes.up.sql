@@ -0,0 +1,2 @@
+ALTER TABLE channel
+    ADD COLUMN IF NOT EXISTS modes JSONB NOT NULL DEFAULT '{}'
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          BIGSERIAL PRIMARY KEY,
+    channel_id  BIGINT                   NOT NULL,
+    actor_id    BIGINT                   NOT NULL,
+    action      TEXT                     NOT NULL,
+    target_type TEXT                     NOT NULL,
+    target_id   BIGINT                   NOT NULL,
+    before      JSONB,
+    after       JSONB,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_channel_id_idx ON audit_log (channel_id, id DESC);
+
+-- The audit log is append-only
+CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS report_reasons;
+DROP TABLE IF EXISTS reports;
\ No newline at end of file
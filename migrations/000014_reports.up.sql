@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS reports
+(
+    id             BIGSERIAL PRIMARY KEY,
+    channel_id     BIGINT                   NOT NULL,
+    target_user_id BIGINT                   NOT NULL,
+    message_id     BIGINT,
+    snapshot       bytea,
+    status         TEXT                     NOT NULL DEFAULT 'open',
+    resolution     TEXT,
+    resolved_by    BIGINT,
+    resolved_at    TIMESTAMP WITH TIME ZONE,
+    created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (channel_id) REFERENCES channel (id) ON DELETE CASCADE,
+    FOREIGN KEY (target_user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- Reports of the same message or user aggregate into one open report
+CREATE UNIQUE INDEX IF NOT EXISTS reports_open_target_idx ON reports (channel_id, target_user_id, (COALESCE(message_id, 0))) WHERE status = 'open';
+
+CREATE TABLE IF NOT EXISTS report_reasons
+(
+    report_id   BIGINT                   NOT NULL,
+    reporter_id BIGINT                   NOT NULL,
+    category    TEXT                     NOT NULL,
+    comment     TEXT                     NOT NULL DEFAULT '',
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (report_id, reporter_id),
+    FOREIGN KEY (report_id) REFERENCES reports (id) ON DELETE CASCADE,
+    FOREIGN KEY (reporter_id) REFERENCES users (id) ON DELETE CASCADE
+)
\ No newline at end of file
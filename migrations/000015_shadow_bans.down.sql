@@ -0,0 +1,2 @@
+ALTER TABLE channel_bans
+    DROP COLUMN IF EXISTS shadow
\ No newline at end of file
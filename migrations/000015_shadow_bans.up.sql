@@ -0,0 +1,2 @@
+ALTER TABLE channel_bans
+    ADD COLUMN IF NOT EXISTS shadow BOOLEAN NOT NULL DEFAULT FALSE
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS activated BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Accounts made before email verification existed stay usable
+UPDATE users
+SET activated = TRUE
\ No newline at end of file
@@ -0,0 +1,59 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id   BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS permissions
+(
+    id   BIGSERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions
+(
+    role_id       BIGINT NOT NULL,
+    permission_id BIGINT NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS users_roles
+(
+    user_id    BIGINT                   NOT NULL,
+    role_id    BIGINT                   NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, role_id),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE
+);
+
+INSERT INTO roles (name)
+VALUES ('admin'),
+       ('staff'),
+       ('user')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO permissions (code)
+VALUES ('users:suspend'),
+       ('users:roles'),
+       ('channels:delete'),
+       ('channels:moderation:read')
+ON CONFLICT DO NOTHING;
+
+-- Admins can do everything, staff can look into channels and suspend users
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+   OR (roles.name = 'staff' AND permissions.code IN ('users:suspend', 'channels:moderation:read'))
+ON CONFLICT DO NOTHING;
+
+INSERT INTO users_roles (user_id, role_id)
+SELECT users.id, roles.id
+FROM users,
+     roles
+WHERE roles.name = 'user'
+ON CONFLICT DO NOTHING
\ No newline at end of file
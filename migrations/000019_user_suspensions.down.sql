@@ -0,0 +1 @@
+DROP TABLE IF EXISTS user_suspensions
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_suspensions
+(
+    user_id    BIGINT PRIMARY KEY,
+    reason     TEXT                     NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_by BIGINT                   NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+)
\ No newline at end of file
@@ -0,0 +1,2 @@
+DELETE FROM permissions
+WHERE code = 'audit:read'
\ No newline at end of file
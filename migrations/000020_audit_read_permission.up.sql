@@ -0,0 +1,11 @@
+INSERT INTO permissions (code)
+VALUES ('audit:read')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles,
+     permissions
+WHERE roles.name = 'admin'
+  AND permissions.code = 'audit:read'
+ON CONFLICT DO NOTHING
\ No newline at end of file